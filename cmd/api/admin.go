@@ -0,0 +1,207 @@
+package main
+
+import (
+	"devops/internal/store"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+type ModerationPayload struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// @Summary List users
+// @Description List all users with their role and ban status
+// @Tags admin
+// @Produce json
+// @Success 200 {array} store.User
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 403 {object} Problem "Insufficient role"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/users [get]
+func (app *application) listUsers(c echo.Context) error {
+	users, err := app.store.Users.List(c.Request().Context())
+	if err != nil {
+		return internalError("Failed to retrieve users", err)
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
+// @Summary Ban a user
+// @Description Ban a user and record the decision
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User id"
+// @Param payload body ModerationPayload false "Reason"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid request format"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "User not found"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/users/{id}/ban [post]
+func (app *application) banUser(c echo.Context) error {
+	return app.setUserBanned(c, true)
+}
+
+// @Summary Unban a user
+// @Description Lift a ban and record the decision
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "User id"
+// @Param payload body ModerationPayload false "Reason"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid request format"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "User not found"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/users/{id}/unban [post]
+func (app *application) unbanUser(c echo.Context) error {
+	return app.setUserBanned(c, false)
+}
+
+func (app *application) setUserBanned(c echo.Context, banned bool) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return badRequest("Invalid user ID")
+	}
+	var req ModerationPayload
+	if err := c.Bind(&req); err != nil {
+		return badRequest("Invalid request format")
+	}
+	if err := Validate.Struct(req); err != nil {
+		return validationFailed(err)
+	}
+
+	action := store.ModerationBanUser
+	if !banned {
+		action = store.ModerationUnbanUser
+	}
+	decision := app.moderationAction(c, action, store.TargetUser, id, req.Reason)
+	if err := app.store.Users.SetBanned(c.Request().Context(), id, banned, store.Moderate(decision)); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return notFound("User not found")
+		default:
+			return internalError("Failed to update user", err)
+		}
+	}
+	app.auditModeration(c, decision)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Force-delete a post
+// @Description Delete any post regardless of its author
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Post id"
+// @Param payload body ModerationPayload false "Reason"
+// @Success 204 "No Content"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Post not found"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/posts/{id} [delete]
+func (app *application) forceDeletePost(c echo.Context) error {
+	post := app.getPostFromContext(c)
+	var req ModerationPayload
+	if err := c.Bind(&req); err != nil {
+		return badRequest("Invalid request format")
+	}
+	if err := Validate.Struct(req); err != nil {
+		return validationFailed(err)
+	}
+
+	decision := app.moderationAction(c, store.ModerationDeletePost, store.TargetPost, post.ID, req.Reason)
+	also := append([]store.TxFunc{store.Moderate(decision)}, app.notifyPostAuthor(c, post, "deleted", req.Reason)...)
+	if err := app.store.Posts.Delete(c.Request().Context(), post.ID, also...); err != nil {
+		return internalError("Failed to delete post", err)
+	}
+	app.auditModeration(c, decision)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Hide a post
+// @Description Hide a post from the public feed
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Post id"
+// @Param payload body ModerationPayload false "Reason"
+// @Success 204 "No Content"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Post not found"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/posts/{id}/hide [post]
+func (app *application) hidePost(c echo.Context) error {
+	return app.setPostHidden(c, true)
+}
+
+// @Summary Unhide a post
+// @Description Restore a hidden post to the public feed
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Post id"
+// @Param payload body ModerationPayload false "Reason"
+// @Success 204 "No Content"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Post not found"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/posts/{id}/unhide [post]
+func (app *application) unhidePost(c echo.Context) error {
+	return app.setPostHidden(c, false)
+}
+
+func (app *application) setPostHidden(c echo.Context, hidden bool) error {
+	post := app.getPostFromContext(c)
+	var req ModerationPayload
+	if err := c.Bind(&req); err != nil {
+		return badRequest("Invalid request format")
+	}
+	if err := Validate.Struct(req); err != nil {
+		return validationFailed(err)
+	}
+
+	action, verb := store.ModerationHidePost, "hidden"
+	if !hidden {
+		action, verb = store.ModerationUnhidePost, "restored"
+	}
+	decision := app.moderationAction(c, action, store.TargetPost, post.ID, req.Reason)
+	also := append([]store.TxFunc{store.Moderate(decision)}, app.notifyPostAuthor(c, post, verb, req.Reason)...)
+	if err := app.store.Posts.SetHidden(c.Request().Context(), post.ID, hidden, also...); err != nil {
+		return internalError("Failed to update post", err)
+	}
+	app.auditModeration(c, decision)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// moderationAction describes a decision by the signed-in moderator. Record
+// it with store.Moderate in the transaction that applies the decision.
+func (app *application) moderationAction(c echo.Context, action, targetType string, targetID int64, reason string) *store.ModerationAction {
+	return &store.ModerationAction{
+		ActorID:    app.getAccountFromContext(c).ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Reason:     reason,
+	}
+}
+
+// auditModeration adds a committed decision to the audit log.
+func (app *application) auditModeration(c echo.Context, decision *store.ModerationAction) {
+	app.audit(c, &store.AuditEvent{
+		Action:     "admin." + decision.Action,
+		TargetType: decision.TargetType,
+		TargetID:   strconv.FormatInt(decision.TargetID, 10),
+		Diff:       app.auditDiff(nil, map[string]string{"reason": decision.Reason}),
+	})
+}
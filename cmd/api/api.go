@@ -1,79 +1,211 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"devops/docs"
+	"devops/internal/config"
+	"devops/internal/events"
+	"devops/internal/jobs"
+	"devops/internal/ratelimit"
 	"devops/internal/store"
+	"errors"
 	_ "github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	echoSwagger "github.com/swaggo/echo-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 	"go.uber.org/zap"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// streamingRoutes write their response incrementally and must bypass the
+// timeout middleware, which buffers the whole body.
+var streamingRoutes = map[string]bool{
+	"/v1/admin/audit/export": true,
+	postStreamPath:           true,
+}
+
 type application struct {
-	config config
+	config *config.Config
 	logger *zap.SugaredLogger
 	store  *store.Storage
-}
+	db     *sql.DB
 
-type dbConfig struct {
-	addr               string
-	maxOpenConnections int
-	maxIdleConnections int
-	maxIdleTime        string
-}
+	limiter    ratelimit.Store
+	rateLimits map[string]ratelimit.Policy
 
-type config struct {
-	addr string
-	db   dbConfig
-	env  string
+	broker *events.Broker
+	inbox  *events.Inbox
+	queue  *jobs.Queue
+
+	// shuttingDown flips readiness to failing once shutdown has begun so
+	// load balancers stop routing new traffic before the server drains.
+	shuttingDown atomic.Bool
+
+	workerCtx   context.Context
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
 }
 
 func (app *application) run(mux http.Handler) error {
 	docs.SwaggerInfo.Version = version
-	docs.SwaggerInfo.Host = app.config.addr
+	docs.SwaggerInfo.Host = app.config.Addr
 	docs.SwaggerInfo.BasePath = "/v1"
-	srv := echo.New()
-	srv.Any("/*", echo.WrapHandler(mux))
-	err := srv.Start(app.config.addr)
-	if err != nil {
+
+	srv := &http.Server{
+		Addr:              app.config.Addr,
+		Handler:           mux,
+		ReadTimeout:       app.config.HTTP.ReadTimeout,
+		ReadHeaderTimeout: app.config.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      app.config.HTTP.WriteTimeout,
+		IdleTimeout:       app.config.HTTP.IdleTimeout,
+	}
+	// Streams never finish on their own; end them so Shutdown can drain.
+	srv.RegisterOnShutdown(app.broker.Close)
+	srv.RegisterOnShutdown(app.inbox.Close)
+
+	// Register for signals before serving, so that one arriving early still
+	// drains instead of killing the process.
+	signalled, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdown := make(chan error, 1)
+	go func(signalled context.Context) {
+		<-signalled.Done()
+
+		app.logger.Infow("shutting down server",
+			"drain_delay", app.config.HTTP.DrainDelay,
+			"timeout", app.config.HTTP.ShutdownTimeout)
+		app.shuttingDown.Store(true)
+		// Shutdown closes the listener at once, so keep serving until load
+		// balancers have seen readiness fail and stopped sending traffic.
+		time.Sleep(app.config.HTTP.DrainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.HTTP.ShutdownTimeout)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}(signalled)
+
+	app.logger.Infow("server has started", "addr", app.config.Addr, "env", app.config.Env)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if err := <-shutdown; err != nil {
 		return err
 	}
+
+	app.stopBackground()
+	app.logger.Infow("server has stopped")
 	return nil
 }
 
+// background runs fn in a goroutine that is cancelled and waited for after
+// the HTTP server has drained.
+func (app *application) background(fn func(ctx context.Context)) {
+	app.workers.Add(1)
+	go func() {
+		defer app.workers.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				app.logger.Errorw("background worker panicked", "panic", r)
+			}
+		}()
+		fn(app.workerCtx)
+	}()
+}
+
+func (app *application) stopBackground() {
+	app.stopWorkers()
+	app.workers.Wait()
+}
+
 func (app *application) mount() http.Handler {
 	e := echo.New()
+	e.IPExtractor = app.ipExtractor()
+	e.HTTPErrorHandler = app.httpErrorHandler
 	e.Use(middleware.RequestID())
+	e.Use(otelecho.Middleware(app.config.Tracing.ServiceName, otelecho.WithSkipper(func(c echo.Context) bool {
+		return c.Path() == "/metrics"
+	})))
+	e.Use(app.MetricsMiddleware)
+	e.Use(app.RequestLoggerMiddleware)
 	e.Use(middleware.Recover())
-	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Format: "${time_rfc3339} [${status}] ${method} ${path} ${latency_human}\n",
-	}))
 	e.Use(middleware.TimeoutWithConfig(middleware.TimeoutConfig{
+		Skipper: func(c echo.Context) bool {
+			return streamingRoutes[c.Path()]
+		},
 		Timeout: 60 * time.Second,
 	}))
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     []string{"http://localhost:3000", "http://0.0.0.0:3000", "http://localhost:5173"},
-		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodOptions, http.MethodDelete, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodHead, http.MethodConnect, http.MethodDelete},
-		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-		AllowCredentials: true,
-	}))
-	e.Static("/public", "/app/public")
-	v1 := e.Group("/v1")
+	e.Use(app.corsMiddleware())
+	e.Use(app.securityHeadersMiddleware())
+	e.GET("/public*", echo.StaticDirectoryHandler(os.DirFS(path), false), staticCacheMiddleware)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	v1 := e.Group("/v1", app.csrfMiddleware(), app.rateLimit("default"))
 	v1.GET("/health", app.healthCheckHandler)
+	v1.GET("/health/live", app.livenessHandler)
+	v1.GET("/health/ready", app.readinessHandler)
+	v1.GET("/csrf", app.getCSRFToken)
+	v1.POST("/csp-report", app.cspReportHandler)
 	v1.GET("/swagger/*", echoSwagger.WrapHandler)
-	e.GET("/auth/:provider/callback", app.getAuthCallback)
-	v1.GET("/auth/:provider", app.startAuthHandler)
+	e.GET("/auth/:provider/callback", app.getAuthCallback, app.rateLimit("auth"))
+	v1.GET("/auth/:provider", app.startAuthHandler, app.rateLimit("auth"))
 	v1.GET("/auth/logout/:provider", app.logout)
 
 	posts := v1.Group("/post")
 
-	posts.POST("", app.createPost)
+	postWrites := app.rateLimit("post_write")
+	posts.POST("", app.createPost, app.AuthMiddleware, postWrites, app.IdempotencyMiddleware)
 	posts.GET("", app.getPosts)
-	postsID := posts.Group("/:id")
-	postsID.PATCH("", app.editPost)
-	postsID.DELETE("", app.deletePost)
+	posts.GET("/stream", app.streamPosts)
+	postsID := posts.Group("/:id", app.PostContextMiddleware)
+	postsID.GET("", app.getPost)
+	postsID.PATCH("", app.editPost, app.AuthMiddleware, app.PostAuthorMiddleware, postWrites)
+	postsID.DELETE("", app.deletePost, app.AuthMiddleware, app.PostAuthorMiddleware, postWrites)
+
+	notifications := v1.Group("/notifications", app.AuthMiddleware)
+	notifications.GET("", app.listNotifications)
+	notifications.POST("/read-all", app.markAllNotificationsRead)
+	notifications.POST("/:id/read", app.markNotificationRead)
+	notifications.GET("/preferences", app.getNotificationPreferences)
+	notifications.PUT("/preferences", app.updateNotificationPreferences)
+
+	admin := v1.Group("/admin", app.AuthMiddleware)
+	adminUsers := admin.Group("/users", app.RoleMiddleware(store.RoleAdmin))
+	adminUsers.GET("", app.listUsers)
+	adminUsers.POST("/:id/ban", app.banUser)
+	adminUsers.POST("/:id/unban", app.unbanUser)
+
+	adminPosts := admin.Group("/posts/:id", app.RoleMiddleware(store.RoleModerator), app.PostContextMiddleware)
+	adminPosts.DELETE("", app.forceDeletePost)
+	adminPosts.POST("/hide", app.hidePost)
+	adminPosts.POST("/unhide", app.unhidePost)
+
+	adminAudit := admin.Group("/audit", app.RoleMiddleware(store.RoleAdmin))
+	adminAudit.GET("", app.getAuditEvents)
+	adminAudit.GET("/export", app.exportAuditEvents)
+
+	adminJobs := admin.Group("/jobs", app.RoleMiddleware(store.RoleAdmin))
+	adminJobs.GET("", app.listJobs)
+	adminJobs.GET("/:id", app.getJob)
+	adminJobs.DELETE("/:id", app.deleteJob)
+	adminJobs.POST("/:id/retry", app.retryJob)
+
+	adminWebhooks := admin.Group("/webhooks", app.RoleMiddleware(store.RoleAdmin))
+	adminWebhooks.GET("", app.listWebhooks)
+	adminWebhooks.POST("", app.createWebhook)
+	adminWebhooks.GET("/:id", app.getWebhook)
+	adminWebhooks.PATCH("/:id", app.updateWebhook)
+	adminWebhooks.DELETE("/:id", app.deleteWebhook)
+	adminWebhooks.GET("/:id/deliveries", app.listWebhookDeliveries)
+	adminWebhooks.GET("/:id/deliveries/:delivery_id/attempts", app.listWebhookAttempts)
+	adminWebhooks.POST("/:id/deliveries/:delivery_id/redeliver", app.redeliverWebhook)
 	return e
 }
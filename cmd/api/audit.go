@@ -0,0 +1,174 @@
+package main
+
+import (
+	"devops/internal/store"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 200
+)
+
+type AuditPage struct {
+	Events     []*store.AuditEvent `json:"events"`
+	NextCursor int64               `json:"next_cursor,omitempty"`
+}
+
+// audit records an event for the current request. Failures are logged rather
+// than returned because the audited change has already been committed.
+func (app *application) audit(c echo.Context, event *store.AuditEvent) {
+	if event.ActorEmail == "" {
+		event.ActorEmail = app.getUserFromContext(c)
+	}
+	event.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	event.IP = c.RealIP()
+
+	if err := app.store.Audit.Create(c.Request().Context(), event); err != nil {
+		app.requestLogger(c).Errorw("failed to write audit event",
+			"action", event.Action,
+			"target_type", event.TargetType,
+			"target_id", event.TargetID,
+			"error", err)
+	}
+}
+
+// pageLimit returns the page size for a requested limit: def when none was
+// given, and at most max.
+func pageLimit(limit, def, max int) int {
+	switch {
+	case limit <= 0:
+		return def
+	case limit > max:
+		return max
+	}
+	return limit
+}
+
+// auditDiff is a convenience wrapper around store.Diff that drops the diff
+// instead of failing the request when the values cannot be encoded.
+func (app *application) auditDiff(before, after any) json.RawMessage {
+	diff, err := store.Diff(before, after)
+	if err != nil {
+		app.logger.Errorw("failed to compute audit diff", "error", err)
+		return nil
+	}
+	return diff
+}
+
+// @Summary List audit events
+// @Description List audit events, newest first, filtered by actor, action, target and time range
+// @Tags admin
+// @Produce json
+// @Param actor query string false "Actor email"
+// @Param action query string false "Action, e.g. post.edit"
+// @Param target_type query string false "Target type"
+// @Param target_id query string false "Target id"
+// @Param from query string false "Start time (RFC 3339)"
+// @Param to query string false "End time (RFC 3339)"
+// @Param cursor query int false "Return events older than this id"
+// @Param limit query int false "Page size (max 200)"
+// @Success 200 {object} AuditPage
+// @Failure 400 {object} Problem "Invalid filter"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/audit [get]
+func (app *application) getAuditEvents(c echo.Context) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return badRequest("Invalid filter")
+	}
+	filter.Limit = pageLimit(filter.Limit, defaultAuditLimit, maxAuditLimit)
+
+	events, err := app.store.Audit.List(c.Request().Context(), filter)
+	if err != nil {
+		return internalError("Failed to retrieve audit events", err)
+	}
+
+	page := AuditPage{Events: events}
+	if page.Events == nil {
+		page.Events = []*store.AuditEvent{}
+	}
+	if len(events) == filter.Limit {
+		page.NextCursor = events[len(events)-1].ID
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Export audit events
+// @Description Stream every matching audit event as newline-delimited JSON
+// @Tags admin
+// @Produce application/x-ndjson
+// @Param actor query string false "Actor email"
+// @Param action query string false "Action, e.g. post.edit"
+// @Param target_type query string false "Target type"
+// @Param target_id query string false "Target id"
+// @Param from query string false "Start time (RFC 3339)"
+// @Param to query string false "End time (RFC 3339)"
+// @Success 200 {string} string "NDJSON stream"
+// @Failure 400 {object} Problem "Invalid filter"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Router /admin/audit/export [get]
+func (app *application) exportAuditEvents(c echo.Context) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return badRequest("Invalid filter")
+	}
+
+	res := c.Response()
+	// Large exports can outlive the server's write timeout.
+	_ = http.NewResponseController(res).SetWriteDeadline(time.Time{})
+	res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit.ndjson"`)
+	res.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(res)
+	err = app.store.Audit.Export(c.Request().Context(), filter, func(event *store.AuditEvent) error {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	})
+	if err != nil {
+		// Headers are already sent, so the client only sees a truncated stream.
+		app.requestLogger(c).Errorw("audit export aborted", "error", err)
+	}
+	return nil
+}
+
+func parseAuditFilter(c echo.Context) (store.AuditFilter, error) {
+	filter := store.AuditFilter{
+		ActorEmail: c.QueryParam("actor"),
+		Action:     c.QueryParam("action"),
+		TargetType: c.QueryParam("target_type"),
+		TargetID:   c.QueryParam("target_id"),
+	}
+
+	var err error
+	if v := c.QueryParam("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, err
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, err
+		}
+	}
+	if v := c.QueryParam("cursor"); v != "" {
+		if filter.Cursor, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return filter, err
+		}
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
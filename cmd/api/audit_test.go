@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestPageLimit(t *testing.T) {
+	tests := []struct {
+		limit, want int
+	}{
+		{0, 50},
+		{-1, 50},
+		{20, 20},
+		{200, 200},
+		{500, 200},
+	}
+	for _, tt := range tests {
+		if got := pageLimit(tt.limit, 50, 200); got != tt.want {
+			t.Errorf("pageLimit(%d): expected %d, got %d", tt.limit, tt.want, got)
+		}
+	}
+}
@@ -6,50 +6,77 @@ import (
 	"errors"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"net/url"
 )
 
-// @Summary Auth getAuthCallback
-// @Description Callback for authentication
+// @Summary Auth start auth handler
+// @Description Redirects the browser to the provider's consent screen. After login the browser is sent back to redirect_to, which must match an allowed frontend origin.
 // @Tags Auth
-// @Accept json
-// @Produce json
 // @Param provider path string true "Provider name"
-// @Success 200 {object} object
-// @Failure 500 {object} error
+// @Param redirect_to query string false "SPA URL to return to after login"
+// @Success 302 "Redirect to provider"
+// @Failure 400 {object} Problem "Invalid provider or redirect target"
+// @Failure 429 {object} Problem "Too many requests"
 // @Router /auth/{provider} [get]
 func (app *application) startAuthHandler(c echo.Context) error {
 	provider := c.Param("provider")
-	auth.BeginAuth(c.Response(), c.Request(), provider)
-	return nil
+	authURL, err := auth.BeginAuth(c.Response(), c.Request(), provider, c.QueryParam("redirect_to"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidRedirect):
+			return badRequest("Redirect target is not allowed")
+		default:
+			return badRequest("Unknown auth provider")
+		}
+	}
+	return c.Redirect(http.StatusFound, authURL)
 }
 
-// @Summary Auth start auth handler
-// @Description Callback for authentication
+// @Summary Auth getAuthCallback
+// @Description Completes authentication and redirects the browser back to the SPA with the session cookie set. On failure the SPA URL carries an auth_error query parameter.
 // @Tags Auth
-// @Accept json
-// @Produce json
 // @Param provider path string true "Provider name"
-// @Success 200 {object} object
-// @Failure 500 {object} error
+// @Param state query string true "OAuth state"
+// @Param code query string true "Authorization code"
+// @Success 302 "Redirect to SPA"
 // @Router /auth/{provider}/callback [get]
 func (app *application) getAuthCallback(c echo.Context) error {
 	provider := c.Param("provider")
-	user, err := auth.CompleteAuth(c.Response(), c.Request(), provider)
+	user, redirectTo, err := auth.CompleteAuth(c.Response(), c.Request(), provider)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		app.requestLogger(c).Warnw("oauth callback failed", "provider", provider, "error", err)
+		reason := "auth_failed"
+		if errors.Is(err, auth.ErrStateMismatch) {
+			reason = "state_mismatch"
+		}
+		return c.Redirect(http.StatusFound, withQuery(redirectTo, "auth_error", reason))
+	}
+
+	_, err = app.store.Users.CreateUser(c.Request().Context(), user.FirstName, user.Email)
+	if err != nil && !errors.Is(err, store.ViolatePK) {
+		app.requestLogger(c).Errorw("failed to create user", "email", user.Email, "error", err)
+		_ = auth.Logout(c.Response(), c.Request())
+		return c.Redirect(http.StatusFound, withQuery(redirectTo, "auth_error", "server_error"))
 	}
+	app.audit(c, &store.AuditEvent{
+		ActorEmail: user.Email,
+		Action:     store.AuditAuthLogin,
+		TargetType: store.TargetUser,
+		TargetID:   user.Email,
+		Diff:       app.auditDiff(nil, map[string]string{"provider": provider}),
+	})
+	return c.Redirect(http.StatusFound, redirectTo)
+}
 
-	_, err = app.store.Users.CreateUser(user.FirstName, user.Email)
+func withQuery(target, key, value string) string {
+	u, err := url.Parse(target)
 	if err != nil {
-		switch {
-		case errors.Is(err, store.ViolatePK):
-			return c.JSON(http.StatusTemporaryRedirect, map[string]string{
-				"message": "user already exists, redirecting to login"})
-		default:
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-		}
+		return target
 	}
-	return c.JSON(http.StatusOK, user)
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
 }
 
 // @Summary Logout handler
@@ -57,7 +84,7 @@ func (app *application) logout(c echo.Context) error {
 	provider := c.Param("provider")
 
 	if err := auth.Logout(c.Response(), c.Request()); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return internalError("Failed to log out", err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
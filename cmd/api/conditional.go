@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"devops/internal/store"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The feed is polled, so clients always revalidate and get a 304 when
+	// nothing changed.
+	postListCacheControl = "no-cache"
+	postCacheControl     = "public, max-age=30, must-revalidate"
+)
+
+// postETag identifies a single post revision.
+func postETag(post *store.Post) string {
+	return `"` + strconv.FormatInt(post.ID, 10) + "-" + strconv.FormatInt(post.UpdatedAt.UnixNano(), 36) + `"`
+}
+
+// postListETag hashes the id and revision of every post in the feed, so it
+// also changes when posts are deleted or hidden.
+func postListETag(posts []*store.Post) string {
+	h := sha256.New()
+	var buf [16]byte
+	for _, post := range posts {
+		binary.BigEndian.PutUint64(buf[:8], uint64(post.ID))
+		binary.BigEndian.PutUint64(buf[8:], uint64(post.UpdatedAt.UnixNano()))
+		h.Write(buf[:])
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+func postListLastModified(posts []*store.Post) time.Time {
+	var last time.Time
+	for _, post := range posts {
+		if post.UpdatedAt.After(last) {
+			last = post.UpdatedAt
+		}
+	}
+	return last
+}
+
+// notModified sets the validators and Cache-Control for a representation and
+// reports whether the request's conditional headers show that the client's
+// copy is current. If-Modified-Since is only consulted without
+// If-None-Match, as RFC 9110 requires, which matters for the feed: deleting a
+// post changes its ETag but not its Last-Modified.
+func notModified(c echo.Context, etag string, lastModified time.Time, cacheControl string) bool {
+	h := c.Response().Header()
+	h.Set(echo.HeaderCacheControl, cacheControl)
+	h.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		h.Set(echo.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	req := c.Request()
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if ims := req.Header.Get(echo.HeaderIfModifiedSince); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// etagMatches implements the weak comparison used for If-None-Match.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"devops/internal/store"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 600, time.UTC)
+	etag := `"abc"`
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no conditions", nil, false},
+		{"matching etag", map[string]string{"If-None-Match": `"x", W/"abc"`}, true},
+		{"wildcard", map[string]string{"If-None-Match": "*"}, true},
+		{"stale etag", map[string]string{"If-None-Match": `"x"`}, false},
+		{"not modified since", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, true},
+		{"modified since", map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, false},
+		{"etag wins over date", map[string]string{
+			"If-None-Match":     `"x"`,
+			"If-Modified-Since": modified.Format(http.TimeFormat),
+		}, false},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		if got := notModified(c, etag, modified, postListCacheControl); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+		if rec.Header().Get("ETag") != etag || rec.Header().Get(echo.HeaderCacheControl) != postListCacheControl {
+			t.Errorf("%s: expected validators to be set, got %v", tc.name, rec.Header())
+		}
+	}
+}
+
+func TestPostListETagChangesOnDelete(t *testing.T) {
+	now := time.Now()
+	a := &store.Post{ID: 1, UpdatedAt: now}
+	b := &store.Post{ID: 2, UpdatedAt: now.Add(-time.Hour)}
+
+	if postListETag([]*store.Post{a, b}) == postListETag([]*store.Post{a}) {
+		t.Error("Expected removing a post to change the list ETag")
+	}
+	if postListETag([]*store.Post{a, b}) != postListETag([]*store.Post{a, b}) {
+		t.Error("Expected the list ETag to be stable")
+	}
+}
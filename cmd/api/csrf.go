@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfContextKey = "csrf"
+	csrfCookieName = "_csrf"
+)
+
+type CSRFTokenResponse struct {
+	Token string `json:"csrf_token"`
+}
+
+// csrfMiddleware implements the double-submit cookie pattern: the token is kept
+// in an HttpOnly cookie and must be echoed back in the X-CSRF-Token header on
+// unsafe methods. Bearer-authenticated requests carry no ambient credentials
+// and are exempt.
+func (app *application) csrfMiddleware() echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper:        csrfExempt,
+		TokenLookup:    "header:" + echo.HeaderXCSRFToken + ",form:_csrf",
+		ContextKey:     csrfContextKey,
+		CookieName:     csrfCookieName,
+		CookiePath:     "/",
+		CookieHTTPOnly: true,
+		CookieSecure:   app.config.IsProd(),
+		CookieSameSite: http.SameSiteLaxMode,
+		ErrorHandler: func(err error, c echo.Context) error {
+			return forbidden("Invalid CSRF token")
+		},
+	})
+}
+
+// csrfExempt skips bearer-authenticated requests and browser-generated CSP
+// reports, which can never carry a token.
+func csrfExempt(c echo.Context) bool {
+	return hasBearerToken(c) || c.Path() == cspReportPath
+}
+
+func hasBearerToken(c echo.Context) bool {
+	scheme, _, ok := strings.Cut(c.Request().Header.Get(echo.HeaderAuthorization), " ")
+	return ok && strings.EqualFold(scheme, "Bearer")
+}
+
+// @Summary Get CSRF token
+// @Description Returns the CSRF token to send in the X-CSRF-Token header on POST, PATCH and DELETE requests
+// @Tags Auth
+// @Produce json
+// @Success 200 {object} CSRFTokenResponse
+// @Router /csrf [get]
+func (app *application) getCSRFToken(c echo.Context) error {
+	token, _ := c.Get(csrfContextKey).(string)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, CSRFTokenResponse{Token: token})
+}
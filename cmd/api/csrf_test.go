@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	h := testRouter(t, testUsers{})
+	token := newTestClient(t, h, "")
+
+	tests := []struct {
+		name   string
+		target string
+		cookie bool
+		header string
+		bearer bool
+		body   string
+		want   int
+	}{
+		{"no token", "/v1/post", true, "", false, `{}`, http.StatusForbidden},
+		{"no cookie", "/v1/post", false, token.csrf, false, `{}`, http.StatusForbidden},
+		{"wrong token", "/v1/post", true, "not-the-token", false, `{}`, http.StatusForbidden},
+		// Past the CSRF check the anonymous request is stopped by AuthMiddleware.
+		{"matching token", "/v1/post", true, token.csrf, false, `{}`, http.StatusUnauthorized},
+		{"bearer exempt", "/v1/post", false, "", true, `{}`, http.StatusUnauthorized},
+		{"csp report exempt", cspReportPath, false, "", false, `[]`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.cookie {
+				for _, cookie := range token.cookies {
+					req.AddCookie(cookie)
+				}
+			}
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderXCSRFToken, tt.header)
+			}
+			if tt.bearer {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer abc")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetCSRFTokenMatchesCookie(t *testing.T) {
+	h := testRouter(t, testUsers{})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/csrf", nil))
+
+	var res CSRFTokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	var cookie string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == csrfCookieName {
+			cookie = c.Value
+			if !c.HttpOnly {
+				t.Error("Expected the CSRF cookie to be HttpOnly")
+			}
+		}
+	}
+	if res.Token == "" || res.Token != cookie {
+		t.Errorf("Expected the response token to match the cookie, got %q and %q", res.Token, cookie)
+	}
+	if got := rec.Header().Get(echo.HeaderCacheControl); got != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, got %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTypes maps statuses to the problem type URI reported for them.
+// Statuses without an entry use "about:blank", whose title is the status text.
+var problemTypes = map[int]string{
+	http.StatusBadRequest:          "/problems/bad-request",
+	http.StatusUnauthorized:        "/problems/unauthorized",
+	http.StatusForbidden:           "/problems/forbidden",
+	http.StatusNotFound:            "/problems/not-found",
+	http.StatusConflict:            "/problems/conflict",
+	http.StatusUnprocessableEntity: "/problems/validation-error",
+	http.StatusTooManyRequests:     "/problems/rate-limited",
+	http.StatusInternalServerError: "/problems/internal-error",
+	http.StatusServiceUnavailable:  "/problems/unavailable",
+}
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string       `json:"type" example:"/problems/validation-error"`
+	Title    string       `json:"title" example:"Unprocessable Entity"`
+	Status   int          `json:"status" example:"422"`
+	Detail   string       `json:"detail,omitempty" example:"The request body failed validation"`
+	Instance string       `json:"instance,omitempty" example:"p1cXBZkDyYzPIFMDWgGJhDPexGKfPtKe"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field" example:"title"`
+	Code    string `json:"code" example:"required"`
+	Message string `json:"message" example:"title is required"`
+}
+
+// APIError is returned by handlers and middleware and rendered as a Problem
+// by httpErrorHandler. Type overrides the default problem type for Status.
+// Err is the internal cause; it is logged but never sent to the client.
+type APIError struct {
+	Status int
+	Type   string
+	Detail string
+	Errors []FieldError
+	Err    error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Status, e.Detail)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+func badRequest(detail string) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Detail: detail}
+}
+
+func unauthorized(detail string) *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Detail: detail}
+}
+
+func forbidden(detail string) *APIError {
+	return &APIError{Status: http.StatusForbidden, Detail: detail}
+}
+
+func notFound(detail string) *APIError {
+	return &APIError{Status: http.StatusNotFound, Detail: detail}
+}
+
+func conflict(detail string) *APIError {
+	return &APIError{Status: http.StatusConflict, Detail: detail}
+}
+
+func tooManyRequests(detail string) *APIError {
+	return &APIError{Status: http.StatusTooManyRequests, Detail: detail}
+}
+
+func internalError(detail string, err error) *APIError {
+	return &APIError{Status: http.StatusInternalServerError, Detail: detail, Err: err}
+}
+
+// validationFailed turns the result of Validate.Struct into a 422 with one
+// entry per failing field.
+func validationFailed(err error) *APIError {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return internalError("Failed to validate request", err)
+	}
+	apiErr := &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Detail: "The request body failed validation",
+	}
+	for _, fe := range verrs {
+		apiErr.Errors = append(apiErr.Errors, fieldError(fe))
+	}
+	return apiErr
+}
+
+func fieldError(fe validator.FieldError) FieldError {
+	// Drop the struct name so the path matches the JSON body, e.g. "title".
+	field := fe.Namespace()
+	if _, rest, ok := strings.Cut(field, "."); ok {
+		field = rest
+	}
+
+	var msg string
+	switch fe.Tag() {
+	case "required":
+		msg = "is required"
+	case "min", "max":
+		bound := "at least"
+		if fe.Tag() == "max" {
+			bound = "at most"
+		}
+		msg = fmt.Sprintf("must be %s %s", bound, fe.Param())
+		if fe.Kind() == reflect.String {
+			msg += " characters"
+		}
+	case "oneof":
+		msg = "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "url":
+		msg = "must be a valid URL"
+	case "email":
+		msg = "must be a valid email address"
+	default:
+		msg = fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+	return FieldError{Field: field, Code: fe.Tag(), Message: field + " " + msg}
+}
+
+// httpErrorHandler renders every error that reaches echo as
+// application/problem+json and logs the cause of server errors.
+func (app *application) httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var apiErr *APIError
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &httpErr):
+		apiErr = &APIError{Status: httpErr.Code, Err: httpErr.Internal}
+		if msg, ok := httpErr.Message.(string); ok && msg != http.StatusText(httpErr.Code) {
+			apiErr.Detail = msg
+		}
+	default:
+		apiErr = internalError("Internal server error", err)
+	}
+
+	if apiErr.Status >= http.StatusInternalServerError {
+		app.requestLogger(c).Errorw("request failed",
+			"status", apiErr.Status,
+			"detail", apiErr.Detail,
+			"error", apiErr.Err)
+	}
+
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(apiErr.Status),
+		Status:   apiErr.Status,
+		Detail:   apiErr.Detail,
+		Instance: c.Response().Header().Get(echo.HeaderXRequestID),
+		Errors:   apiErr.Errors,
+	}
+	if t, ok := problemTypes[apiErr.Status]; ok {
+		problem.Type = t
+	}
+	if apiErr.Type != "" {
+		problem.Type = apiErr.Type
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, problemContentType)
+		err = c.JSON(apiErr.Status, problem)
+	}
+	if err != nil {
+		app.requestLogger(c).Errorw("failed to write error response", "error", err)
+	}
+}
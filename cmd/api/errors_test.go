@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveError(t *testing.T, err error) (*httptest.ResponseRecorder, Problem) {
+	t.Helper()
+	app := &application{logger: zap.NewNop().Sugar()}
+	e := echo.New()
+	e.HTTPErrorHandler = app.httpErrorHandler
+	e.GET("/", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderXRequestID, "req-1")
+		return err
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var problem Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to decode problem: %v", err)
+	}
+	return rec, problem
+}
+
+func TestHTTPErrorHandlerValidation(t *testing.T) {
+	err := Validate.Struct(CreatePostPayload{Content: "x", Format: "html"})
+	rec, problem := serveError(t, validationFailed(err))
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != problemContentType {
+		t.Errorf("Expected Content-Type %s, got %s", problemContentType, ct)
+	}
+	if problem.Type != "/problems/validation-error" || problem.Instance != "req-1" {
+		t.Errorf("Unexpected problem %+v", problem)
+	}
+
+	want := map[string]string{
+		"title":  "title is required",
+		"format": "format must be one of: plain, markdown",
+	}
+	if len(problem.Errors) != len(want) {
+		t.Fatalf("Expected %d field errors, got %+v", len(want), problem.Errors)
+	}
+	for _, fe := range problem.Errors {
+		if want[fe.Field] != fe.Message {
+			t.Errorf("Expected %q for %s, got %q", want[fe.Field], fe.Field, fe.Message)
+		}
+	}
+}
+
+func TestHTTPErrorHandlerHidesInternalCause(t *testing.T) {
+	rec, problem := serveError(t, internalError("Failed to create post", errors.New("pq: connection refused")))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "pq:") {
+		t.Errorf("Expected internal cause to be hidden, got %s", rec.Body.String())
+	}
+	if problem.Detail != "Failed to create post" {
+		t.Errorf("Expected detail to be kept, got %q", problem.Detail)
+	}
+}
+
+func TestHTTPErrorHandlerEchoErrors(t *testing.T) {
+	rec, problem := serveError(t, echo.ErrMethodNotAllowed)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+	if problem.Type != "about:blank" || problem.Title != "Method Not Allowed" || problem.Detail != "" {
+		t.Errorf("Unexpected problem %+v", problem)
+	}
+}
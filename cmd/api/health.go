@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"devops/internal/db"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 )
 
 // HealthCheckAPI  godoc
@@ -18,7 +25,128 @@ func (app *application) healthCheckHandler(c echo.Context) error {
 	data := map[string]string{
 		"version": version,
 		"status":  "OK",
-		"env":     app.config.env,
+		"env":     app.config.Env,
 	}
 	return c.JSON(http.StatusOK, data)
 }
+
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck is the result of one readiness check. Probes are
+// unauthenticated, so the cause of a failure is only logged.
+type HealthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// @Summary Liveness probe
+// @Description Reports that the process is up and serving requests
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /health/live [get]
+func (app *application) livenessHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "OK"})
+}
+
+// @Summary Readiness probe
+// @Description Checks the database, schema version and blob storage. Fails while the server is shutting down.
+// @Tags health
+// @Produce json
+// @Success 200 {object} ReadinessResponse
+// @Failure 503 {object} ReadinessResponse
+// @Router /health/ready [get]
+func (app *application) readinessHandler(c echo.Context) error {
+	checks := map[string]func(context.Context) error{
+		"shutdown":   app.checkNotShuttingDown,
+		"database":   app.checkDatabase,
+		"migrations": app.checkMigrations,
+		"storage":    checkBlobStorage,
+	}
+
+	logger := app.requestLogger(c)
+	res := ReadinessResponse{Status: "OK", Checks: make(map[string]HealthCheck, len(checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request().Context(), healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+			result := HealthCheck{Status: "OK", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = "FAIL"
+				logger.Warnw("readiness check failed", "check", name, "error", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[name] = result
+			if err != nil {
+				res.Status = "FAIL"
+			}
+		}()
+	}
+	wg.Wait()
+
+	if res.Status != "OK" {
+		return c.JSON(http.StatusServiceUnavailable, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (app *application) checkNotShuttingDown(context.Context) error {
+	if app.shuttingDown.Load() {
+		return errors.New("server is shutting down")
+	}
+	return nil
+}
+
+func (app *application) checkDatabase(ctx context.Context) error {
+	return app.db.PingContext(ctx)
+}
+
+func (app *application) checkMigrations(ctx context.Context) error {
+	expected, err := db.ExpectedVersion()
+	if err != nil {
+		return err
+	}
+	current, dirty, err := db.CurrentVersion(ctx, app.db)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", current)
+	}
+	if current != expected {
+		return fmt.Errorf("schema version %d, expected %d", current, expected)
+	}
+	return nil
+}
+
+func checkBlobStorage(context.Context) error {
+	f, err := os.CreateTemp(path, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = f.Write([]byte("ok"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	headerIdempotencyKey     = "Idempotency-Key"
+	headerIdempotentReplayed = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	maxIdempotentBodySize    = 1 << 20
+)
+
+// IdempotencyMiddleware makes a route safe to retry. The first request with a
+// given Idempotency-Key claims the key and its response is stored; retries
+// with the same body replay that response, retries with a different body get
+// 422, and retries that arrive while the first request is still running get
+// 409. Server errors release the key so the client can try again.
+//
+// It must be layered after AuthMiddleware: keys are scoped to the signed-in
+// account, so two users picking the same key never see each other's
+// responses.
+func (app *application) IdempotencyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(headerIdempotencyKey)
+		if key == "" {
+			return next(c)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return badRequest("Idempotency-Key must be at most 255 characters")
+		}
+		account := app.getAccountFromContext(c)
+		if account == nil {
+			return unauthorized("Sign-in required")
+		}
+
+		req := c.Request()
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxIdempotentBodySize+1))
+		if err != nil {
+			return badRequest("Invalid request body")
+		}
+		if len(body) > maxIdempotentBodySize {
+			return &APIError{Status: http.StatusRequestEntityTooLarge, Detail: "Request body too large"}
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := strconv.FormatInt(account.ID, 10)
+		fingerprint := requestFingerprint(req, c.Path(), body)
+
+		cfg := app.config.Idempotency
+		rec, acquired, err := app.store.Idempotency.Acquire(req.Context(), scope, key, fingerprint, cfg.LockTimeout, cfg.TTL)
+		if err != nil {
+			return internalError("Failed to check idempotency key", err)
+		}
+		if !acquired {
+			switch {
+			case rec.Fingerprint != fingerprint:
+				return &APIError{
+					Status: http.StatusUnprocessableEntity,
+					Type:   "/problems/idempotency-key-reused",
+					Detail: "Idempotency-Key was already used for a different request",
+				}
+			case rec.Status == 0:
+				c.Response().Header().Set(echo.HeaderRetryAfter, "1")
+				apiErr := conflict("A request with this Idempotency-Key is still in progress")
+				apiErr.Type = "/problems/idempotency-key-in-use"
+				return apiErr
+			}
+			c.Response().Header().Set(headerIdempotentReplayed, "true")
+			return c.Blob(rec.Status, rec.ContentType, rec.Body)
+		}
+
+		res := c.Response()
+		recorder := &bodyRecorder{ResponseWriter: res.Writer}
+		res.Writer = recorder
+		if err := next(c); err != nil {
+			// Commit the error response so that it is the one stored.
+			c.Error(err)
+		}
+		res.Writer = recorder.ResponseWriter
+
+		// The request context may already be cancelled once the client has
+		// gone away, but the outcome still has to be recorded.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), 5*time.Second)
+		defer cancel()
+		if res.Status >= http.StatusInternalServerError {
+			err = app.store.Idempotency.Release(ctx, scope, key)
+		} else {
+			err = app.store.Idempotency.Complete(ctx, scope, key, res.Status, res.Header().Get(echo.HeaderContentType), recorder.body.Bytes())
+		}
+		if err != nil {
+			app.requestLogger(c).Errorw("failed to store idempotent response", "error", err)
+		}
+		return nil
+	}
+}
+
+// requestFingerprint identifies a request by route, content type and body so
+// that a reused key can be told apart from a genuine retry.
+func requestFingerprint(req *http.Request, route string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, req.Method+"\n"+route+"\n"+req.Header.Get(echo.HeaderContentType)+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bodyRecorder copies everything written to the response.
+type bodyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *bodyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
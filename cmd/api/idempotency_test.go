@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"devops/internal/config"
+	"devops/internal/store"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryIdempotency struct {
+	records map[string]*store.IdempotencyRecord
+}
+
+func (m *memoryIdempotency) Acquire(_ context.Context, scope, key, fingerprint string, _, _ time.Duration) (*store.IdempotencyRecord, bool, error) {
+	if rec, ok := m.records[scope+key]; ok {
+		return rec, false, nil
+	}
+	m.records[scope+key] = &store.IdempotencyRecord{Fingerprint: fingerprint}
+	return m.records[scope+key], true, nil
+}
+
+func (m *memoryIdempotency) Complete(_ context.Context, scope, key string, status int, contentType string, body []byte) error {
+	rec := m.records[scope+key]
+	rec.Status, rec.ContentType, rec.Body = status, contentType, body
+	return nil
+}
+
+func (m *memoryIdempotency) Release(_ context.Context, scope, key string) error {
+	delete(m.records, scope+key)
+	return nil
+}
+
+func (m *memoryIdempotency) DeleteExpired(context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	keys := &memoryIdempotency{records: map[string]*store.IdempotencyRecord{}}
+	app := &application{
+		config: &config.Config{},
+		logger: zap.NewNop().Sugar(),
+		store:  &store.Storage{Idempotency: keys},
+	}
+	e := echo.New()
+	e.HTTPErrorHandler = app.httpErrorHandler
+
+	calls := 0
+	e.POST("/post", func(c echo.Context) error {
+		calls++
+		if calls == 1 {
+			return internalError("Failed to create post", context.DeadlineExceeded)
+		}
+		return c.JSON(http.StatusCreated, map[string]int{"id": calls})
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		// Stands in for AuthMiddleware: the X-Account header picks the user.
+		return func(c echo.Context) error {
+			if id, err := strconv.ParseInt(c.Request().Header.Get("X-Account"), 10, 64); err == nil {
+				ctx := context.WithValue(c.Request().Context(), accountCtx, &store.User{ID: id})
+				c.SetRequest(c.Request().WithContext(ctx))
+			}
+			return next(c)
+		}
+	}, app.IdempotencyMiddleware)
+
+	doAs := func(account, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/post", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(headerIdempotencyKey, key)
+		if account != "" {
+			req.Header.Set("X-Account", account)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	do := func(key, body string) *httptest.ResponseRecorder {
+		return doAs("1", key, body)
+	}
+
+	if rec := doAs("", "k0", `{}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without an account, got %d", rec.Code)
+	}
+
+	if rec := do("k1", `{"title":"a"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected first attempt to fail with 500, got %d", rec.Code)
+	}
+	if _, ok := keys.records["1k1"]; ok {
+		t.Fatal("Expected key to be released after a server error")
+	}
+
+	first := do("k1", `{"title":"a"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", first.Code)
+	}
+
+	replay := do("k1", `{"title":"a"}`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("Expected replay of %d %s, got %d %s", first.Code, first.Body, replay.Code, replay.Body)
+	}
+	if replay.Header().Get(headerIdempotentReplayed) != "true" {
+		t.Error("Expected replayed response to be marked")
+	}
+	if calls != 2 {
+		t.Errorf("Expected handler to run twice, ran %d times", calls)
+	}
+
+	if rec := do("k1", `{"title":"b"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a reused key, got %d", rec.Code)
+	}
+
+	if rec := doAs("2", "k1", `{"title":"b"}`); rec.Code != http.StatusCreated || rec.Header().Get(headerIdempotentReplayed) != "" {
+		t.Errorf("Expected another user's key to be separate, got %d", rec.Code)
+	}
+
+	keys.records["1k2"] = &store.IdempotencyRecord{
+		Fingerprint: requestFingerprint(httptest.NewRequest(http.MethodPost, "/post", nil), "/post", []byte(`{}`)),
+	}
+	inProgress := httptest.NewRequest(http.MethodPost, "/post", strings.NewReader(`{}`))
+	inProgress.Header.Set(headerIdempotencyKey, "k2")
+	inProgress.Header.Set("X-Account", "1")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, inProgress)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while the first request is in progress, got %d", rec.Code)
+	}
+}
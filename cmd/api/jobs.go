@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"devops/internal/config"
+	"devops/internal/jobs"
+	"devops/internal/notify"
+	"devops/internal/store"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Job kinds run by this binary.
+const (
+	jobSweepIdempotencyKeys = "idempotency.sweep"
+	jobPrunePostEvents      = "post_events.prune"
+	jobPruneOutbox          = "outbox.prune"
+)
+
+const (
+	defaultJobLimit = 50
+	maxJobLimit     = 200
+)
+
+type JobPage struct {
+	Jobs       []*jobs.Job      `json:"jobs"`
+	Counts     map[string]int64 `json:"counts"`
+	NextCursor int64            `json:"next_cursor,omitempty"`
+}
+
+// newJobWorker registers every job handler and schedule. It is shared by the
+// API, when jobs run in process, and the worker subcommand.
+func newJobWorker(queue *jobs.Queue, storage *store.Storage, cfg *config.Config, logger *zap.SugaredLogger) (*jobs.Worker, error) {
+	w := jobs.NewWorker(queue, cfg.Jobs, logger)
+
+	w.Handle(jobSweepIdempotencyKeys, func(ctx context.Context, _ *jobs.Job) error {
+		_, err := storage.Idempotency.DeleteExpired(ctx)
+		return err
+	})
+	w.Handle(jobPrunePostEvents, func(ctx context.Context, _ *jobs.Job) error {
+		_, err := storage.PostEvents.DeleteBefore(ctx, time.Now().Add(-cfg.Stream.Retention))
+		return err
+	})
+	w.Handle(jobPruneOutbox, func(ctx context.Context, _ *jobs.Job) error {
+		_, err := storage.Outbox.DeleteDispatchedBefore(ctx, time.Now().Add(-cfg.Webhooks.OutboxRetention))
+		return err
+	})
+
+	notifier, err := newNotifier(storage, cfg.Email)
+	if err != nil {
+		return nil, err
+	}
+	w.Handle(notify.JobEmail, notifier.HandleEmail)
+	w.Handle(notify.JobDigest, notifier.HandleDigest)
+
+	schedules := []struct{ kind, spec string }{
+		{jobSweepIdempotencyKeys, "@every 10m"},
+		{jobPrunePostEvents, "@hourly"},
+		{jobPruneOutbox, "@hourly"},
+		{notify.JobDigest, cfg.Email.DigestSchedule},
+	}
+	for _, s := range schedules {
+		if err := w.Schedule(s.kind, s.spec, nil); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// newNotifier builds the notification service. With email disabled it has no
+// mailer and drops what it is given.
+func newNotifier(storage *store.Storage, cfg config.Email) (*notify.Service, error) {
+	renderer, err := notify.NewRenderer()
+	if err != nil {
+		return nil, err
+	}
+	var mailer notify.Mailer
+	if cfg.Enabled {
+		smtp, err := notify.NewSMTPMailer(cfg.SMTPAddr, cfg.Username, cfg.Password, cfg.From)
+		if err != nil {
+			return nil, err
+		}
+		mailer = smtp
+	}
+	return notify.NewService(storage.Users, storage.Notifications, mailer, renderer, cfg.BaseURL), nil
+}
+
+// @Summary List background jobs
+// @Description List jobs, newest first, with the number of jobs in each state. Use state=failed to inspect jobs that ran out of attempts.
+// @Tags admin
+// @Produce json
+// @Param state query string false "queued, running, succeeded or failed"
+// @Param kind query string false "Job kind"
+// @Param cursor query int false "Return jobs older than this id"
+// @Param limit query int false "Page size (max 200)"
+// @Success 200 {object} JobPage
+// @Failure 400 {object} Problem "Invalid filter"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/jobs [get]
+func (app *application) listJobs(c echo.Context) error {
+	filter := jobs.Filter{State: c.QueryParam("state"), Kind: c.QueryParam("kind")}
+	switch filter.State {
+	case "", jobs.StateQueued, jobs.StateRunning, jobs.StateSucceeded, jobs.StateFailed:
+	default:
+		return badRequest("Invalid filter")
+	}
+	var err error
+	if v := c.QueryParam("cursor"); v != "" {
+		if filter.Cursor, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return badRequest("Invalid filter")
+		}
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return badRequest("Invalid filter")
+		}
+	}
+	filter.Limit = pageLimit(filter.Limit, defaultJobLimit, maxJobLimit)
+
+	ctx := c.Request().Context()
+	list, err := app.queue.List(ctx, filter)
+	if err != nil {
+		return internalError("Failed to retrieve jobs", err)
+	}
+	counts, err := app.queue.Counts(ctx)
+	if err != nil {
+		return internalError("Failed to retrieve jobs", err)
+	}
+	page := JobPage{Jobs: list, Counts: counts}
+	if len(list) == filter.Limit {
+		page.NextCursor = list[len(list)-1].ID
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Get a background job
+// @Tags admin
+// @Produce json
+// @Param id path int true "Job id"
+// @Success 200 {object} jobs.Job
+// @Failure 400 {object} Problem "Invalid job ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Job not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/jobs/{id} [get]
+func (app *application) getJob(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return badRequest("Invalid job ID")
+	}
+	job, err := app.queue.Get(c.Request().Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			return notFound("Job not found")
+		default:
+			return internalError("Failed to retrieve job", err)
+		}
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// @Summary Retry a failed job
+// @Description Queue a failed job to run again now with a fresh attempt budget
+// @Tags admin
+// @Produce json
+// @Param id path int true "Job id"
+// @Success 202 "Accepted"
+// @Failure 400 {object} Problem "Invalid job ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Failed job not found"
+// @Failure 409 {object} Problem "A pending copy of this unique job exists"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/jobs/{id}/retry [post]
+func (app *application) retryJob(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return badRequest("Invalid job ID")
+	}
+	if err := app.queue.Retry(c.Request().Context(), id); err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			return notFound("Failed job not found")
+		case errors.Is(err, jobs.ErrDuplicate):
+			return conflict("A pending copy of this unique job exists")
+		default:
+			return internalError("Failed to retry job", err)
+		}
+	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditJobRetry,
+		TargetType: store.TargetJob,
+		TargetID:   strconv.FormatInt(id, 10),
+	})
+	return c.NoContent(http.StatusAccepted)
+}
+
+// @Summary Delete a job
+// @Description Discard a job that is not running, e.g. a failed job that should not be retried
+// @Tags admin
+// @Produce json
+// @Param id path int true "Job id"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid job ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Job not found or running"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/jobs/{id} [delete]
+func (app *application) deleteJob(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return badRequest("Invalid job ID")
+	}
+	if err := app.queue.Delete(c.Request().Context(), id); err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			return notFound("Job not found or running")
+		default:
+			return internalError("Failed to delete job", err)
+		}
+	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditJobDelete,
+		TargetType: store.TargetJob,
+		TargetID:   strconv.FormatInt(id, 10),
+	})
+	return c.NoContent(http.StatusNoContent)
+}
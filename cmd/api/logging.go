@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const loggerContextKey = "logger"
+
+// RequestLoggerMiddleware attaches a request-scoped logger carrying the
+// request and trace IDs to the context and writes one JSON access log line
+// per request once the response has been committed.
+func (app *application) RequestLoggerMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		req := c.Request()
+
+		fields := []any{
+			"request_id", c.Response().Header().Get(echo.HeaderXRequestID),
+			"method", req.Method,
+			"route", c.Path(),
+		}
+		if sc := trace.SpanContextFromContext(req.Context()); sc.IsValid() {
+			fields = append(fields, "trace_id", sc.TraceID().String())
+		}
+		c.Set(loggerContextKey, app.logger.With(fields...))
+
+		err := next(c)
+		if err != nil {
+			c.Error(err)
+		}
+
+		res := c.Response()
+		accessFields := []any{
+			"path", req.URL.Path,
+			"status", res.Status,
+			"latency", time.Since(start),
+			"bytes_in", req.ContentLength,
+			"bytes_out", res.Size,
+			"ip", c.RealIP(),
+		}
+		logger := app.requestLogger(c)
+		switch {
+		case res.Status >= http.StatusInternalServerError:
+			logger.Errorw("request", accessFields...)
+		case res.Status >= http.StatusBadRequest:
+			logger.Warnw("request", accessFields...)
+		default:
+			logger.Infow("request", accessFields...)
+		}
+		return nil
+	}
+}
+
+// requestLogger returns the logger for the current request, falling back to
+// the application logger outside of RequestLoggerMiddleware.
+func (app *application) requestLogger(c echo.Context) *zap.SugaredLogger {
+	if logger, ok := c.Get(loggerContextKey).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return app.logger
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"devops/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// knownMethods are the method label values; anything else is "other".
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// MetricsMiddleware records request counts, latency and in-flight requests
+// labelled by the matched route template rather than the raw path, so that
+// /v1/post/1 and /v1/post/2 share a series.
+func (app *application) MetricsMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request().Method
+		if !knownMethods[method] {
+			// Clients choose the method; keep the label set bounded.
+			method = "other"
+		}
+
+		inFlight := metrics.RequestsInFlight.WithLabelValues(route, method)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		err := next(c)
+		if err != nil {
+			// Commit the error response so the recorded status is the one sent.
+			c.Error(err)
+		}
+
+		status := strconv.Itoa(c.Response().Status)
+		metrics.RequestsTotal.WithLabelValues(route, method, status).Inc()
+		metrics.RequestDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
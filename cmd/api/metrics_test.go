@@ -0,0 +1,32 @@
+package main
+
+import (
+	"devops/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsMiddlewareBoundsMethodLabel(t *testing.T) {
+	app := &application{}
+	handler := app.MetricsMiddleware(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, method := range []string{"BREW", "PROPFIND", http.MethodGet} {
+		c := echo.New().NewContext(httptest.NewRequest(method, "/", nil), httptest.NewRecorder())
+		c.SetPath("/metrics-test")
+		if err := handler(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("/metrics-test", "other", "200")); got != 2 {
+		t.Errorf("Expected unknown methods to share the other series, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("/metrics-test", http.MethodGet, "200")); got != 1 {
+		t.Errorf("Expected GET to keep its own series, got %v", got)
+	}
+}
@@ -6,41 +6,77 @@ import (
 	"devops/internal/store"
 	"errors"
 	"github.com/labstack/echo/v4"
-	"net/http"
 	"strconv"
 )
 
 type userKey string
 
-const userCtx userKey = "user"
+const (
+	userCtx    userKey = "user"
+	accountCtx userKey = "account"
+)
 
+// AuthMiddleware loads the signed-in account and rejects anonymous and banned
+// users. It answers 401 rather than redirecting, so API clients decide for
+// themselves when to start the OAuth flow.
 func (app *application) AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		user, err := auth.GetUserFromSession(c.Request())
 		if err != nil || user.UserID == "" {
-			return c.Redirect(http.StatusTemporaryRedirect, "auth/google")
+			return unauthorized("Sign-in required")
 		}
+		account, err := app.store.Users.GetUserByEmail(c.Request().Context(), user.Email)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				return unauthorized("Sign-in required")
+			default:
+				return internalError("Failed to load account", err)
+			}
+		}
+		if account.Banned {
+			return forbidden("User is banned")
+		}
+		c.Set(loggerContextKey, app.requestLogger(c).With("user_id", account.ID))
 		ctx := context.WithValue(c.Request().Context(), userCtx, user.Email)
+		ctx = context.WithValue(ctx, accountCtx, account)
 		c.SetRequest(c.Request().WithContext(ctx))
 		return next(c)
 	}
 
 }
 
+// RoleMiddleware must be layered after AuthMiddleware and rejects users whose
+// role is below the required one.
+func (app *application) RoleMiddleware(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			account := app.getAccountFromContext(c)
+			if account == nil {
+				return unauthorized("Sign-in required")
+			}
+			if !account.HasRole(role) {
+				return forbidden("Insufficient role")
+			}
+			return next(c)
+		}
+	}
+}
+
 func (app *application) PostContextMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		postID := c.Param("id")
 		id, err := strconv.ParseInt(postID, 10, 64)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid post ID"})
+			return badRequest("Invalid post ID")
 		}
 		post, err := app.store.Posts.GetByID(c.Request().Context(), id)
 		if err != nil {
 			switch {
 			case errors.Is(err, store.ErrNotFound):
-				return c.JSON(http.StatusNotFound, map[string]string{"error": "Post not found"})
+				return notFound("Post not found")
 			default:
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+				return internalError("Failed to load post", err)
 			}
 		}
 
@@ -49,3 +85,19 @@ func (app *application) PostContextMiddleware(next echo.HandlerFunc) echo.Handle
 		return next(c)
 	}
 }
+
+// PostAuthorMiddleware must be layered after AuthMiddleware and
+// PostContextMiddleware. It only lets the post's author or a moderator through.
+func (app *application) PostAuthorMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		account := app.getAccountFromContext(c)
+		if account == nil {
+			return unauthorized("Sign-in required")
+		}
+		post := app.getPostFromContext(c)
+		if post.AuthorEmail != account.Email && !account.HasRole(store.RoleModerator) {
+			return forbidden("Not the author of this post")
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"devops/internal/content"
+	"devops/internal/notify"
+	"devops/internal/store"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultNotificationLimit = 20
+	maxNotificationLimit     = 100
+
+	mentionExcerptLength = 200
+)
+
+type NotificationPage struct {
+	Notifications []*store.Notification `json:"notifications"`
+	Unread        int64                 `json:"unread"`
+	NextCursor    int64                 `json:"next_cursor,omitempty"`
+}
+
+// MarkAllReadResponse reports how many notifications were marked read.
+type MarkAllReadResponse struct {
+	Updated int64 `json:"updated"`
+}
+
+// @Summary List notifications
+// @Description Return the signed-in user's inbox, newest first, with the total number of unread notifications
+// @Tags notifications
+// @Produce json
+// @Param unread query bool false "Only unread notifications"
+// @Param cursor query int false "Return notifications older than this id"
+// @Param limit query int false "Page size (max 100)"
+// @Success 200 {object} NotificationPage
+// @Failure 400 {object} Problem "Invalid query"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /notifications [get]
+func (app *application) listNotifications(c echo.Context) error {
+	filter := store.InboxFilter{Limit: defaultNotificationLimit}
+	var err error
+	if raw := c.QueryParam("unread"); raw != "" {
+		if filter.UnreadOnly, err = strconv.ParseBool(raw); err != nil {
+			return badRequest("Invalid unread flag")
+		}
+	}
+	if raw := c.QueryParam("cursor"); raw != "" {
+		if filter.Cursor, err = strconv.ParseInt(raw, 10, 64); err != nil || filter.Cursor < 0 {
+			return badRequest("Invalid cursor")
+		}
+	}
+	if raw := c.QueryParam("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil || filter.Limit < 1 || filter.Limit > maxNotificationLimit {
+			return badRequest("Invalid limit")
+		}
+	}
+
+	ctx := c.Request().Context()
+	userID := app.getAccountFromContext(c).ID
+	notifications, err := app.store.Notifications.ListInbox(ctx, userID, filter)
+	if err != nil {
+		return internalError("Failed to retrieve notifications", err)
+	}
+	unread, err := app.store.Notifications.UnreadCount(ctx, userID)
+	if err != nil {
+		return internalError("Failed to count unread notifications", err)
+	}
+
+	page := NotificationPage{Notifications: notifications, Unread: unread}
+	if len(notifications) == filter.Limit {
+		page.NextCursor = notifications[len(notifications)-1].ID
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary Mark a notification read
+// @Description Mark one of the signed-in user's notifications as read
+// @Tags notifications
+// @Param id path int true "Notification id"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid notification ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Notification not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /notifications/{id}/read [post]
+func (app *application) markNotificationRead(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return badRequest("Invalid notification ID")
+	}
+	err = app.store.Notifications.MarkRead(c.Request().Context(), app.getAccountFromContext(c).ID, id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return notFound("Notification not found")
+	case err != nil:
+		return internalError("Failed to update notification", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary Mark all notifications read
+// @Description Mark the signed-in user's unread notifications as read. Pass up_to, the newest id the client has shown, to leave later arrivals unread.
+// @Tags notifications
+// @Produce json
+// @Param up_to query int false "Only mark notifications up to this id"
+// @Success 200 {object} MarkAllReadResponse
+// @Failure 400 {object} Problem "Invalid up_to"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /notifications/read-all [post]
+func (app *application) markAllNotificationsRead(c echo.Context) error {
+	var upTo int64
+	if raw := c.QueryParam("up_to"); raw != "" {
+		var err error
+		if upTo, err = strconv.ParseInt(raw, 10, 64); err != nil || upTo < 0 {
+			return badRequest("Invalid up_to")
+		}
+	}
+	n, err := app.store.Notifications.MarkAllRead(c.Request().Context(), app.getAccountFromContext(c).ID, upTo)
+	if err != nil {
+		return internalError("Failed to update notifications", err)
+	}
+	return c.JSON(http.StatusOK, MarkAllReadResponse{Updated: n})
+}
+
+// NotificationPreferencesPayload replaces the caller's preferences. Email is
+// instant, digest or off; kinds listed in MutedKinds are never emailed.
+type NotificationPreferencesPayload struct {
+	Email      string   `json:"email" validate:"required,oneof=instant digest off"`
+	MutedKinds []string `json:"muted_kinds" validate:"dive,oneof=mention moderation"`
+}
+
+// @Summary Get notification preferences
+// @Description Return the signed-in user's notification preferences
+// @Tags notifications
+// @Produce json
+// @Success 200 {object} store.NotificationPreferences
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /notifications/preferences [get]
+func (app *application) getNotificationPreferences(c echo.Context) error {
+	prefs, err := app.store.Notifications.GetPreferences(c.Request().Context(), app.getAccountFromContext(c).ID)
+	if err != nil {
+		return internalError("Failed to retrieve notification preferences", err)
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+// @Summary Update notification preferences
+// @Description Replace the signed-in user's notification preferences
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Param payload body NotificationPreferencesPayload true "Preferences"
+// @Success 200 {object} store.NotificationPreferences
+// @Failure 400 {object} Problem "Invalid request format"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /notifications/preferences [put]
+func (app *application) updateNotificationPreferences(c echo.Context) error {
+	var req NotificationPreferencesPayload
+	if err := c.Bind(&req); err != nil {
+		return badRequest("Invalid request format")
+	}
+	if err := Validate.Struct(req); err != nil {
+		return validationFailed(err)
+	}
+
+	prefs := &store.NotificationPreferences{
+		UserID:     app.getAccountFromContext(c).ID,
+		Email:      req.Email,
+		MutedKinds: req.MutedKinds,
+	}
+	if prefs.MutedKinds == nil {
+		prefs.MutedKinds = []string{}
+	}
+	if err := app.store.Notifications.SetPreferences(c.Request().Context(), prefs); err != nil {
+		return internalError("Failed to update notification preferences", err)
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+// notifyPostAuthor returns the writes that tell the author of post that a
+// moderator acted on it. Pass them to the store write that applies the
+// decision so the notification commits with it. Nothing is returned when
+// the author cannot be loaded or is the moderator.
+func (app *application) notifyPostAuthor(c echo.Context, post *store.Post, action, reason string) []store.TxFunc {
+	author, err := app.store.Users.GetUserByEmail(c.Request().Context(), post.AuthorEmail)
+	if err != nil {
+		app.requestLogger(c).Warnw("failed to load post author for notification", "post_id", post.ID, "error", err)
+		return nil
+	}
+	if actor := app.getAccountFromContext(c); actor != nil && actor.ID == author.ID {
+		return nil
+	}
+
+	return []store.TxFunc{func(ctx context.Context, tx *sql.Tx) error {
+		return notify.Enqueue(ctx, tx, &notify.Notification{
+			Kind:   notify.KindModeration,
+			UserID: author.ID,
+			Data: map[string]string{
+				"post_id":    strconv.FormatInt(post.ID, 10),
+				"post_title": post.Title,
+				"action":     action,
+				"reason":     reason,
+			},
+		})
+	}}
+}
+
+// notifyMentions returns the writes that tell the users mentioned in post
+// that they were, skipping the author and anyone already mentioned in
+// previous, the entities before an edit. Like notifyPostAuthor they are
+// meant for the transaction that saves the post; post.ID is read when they
+// run, so they work for a post that is being created.
+func (app *application) notifyMentions(c echo.Context, post *store.Post, previous store.Entities) []store.TxFunc {
+	already := map[int64]bool{}
+	for _, id := range content.MentionedUsers(previous) {
+		already[id] = true
+	}
+	var recipients []int64
+	for _, id := range content.MentionedUsers(post.Entities) {
+		if !already[id] {
+			recipients = append(recipients, id)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	actor, authorID := post.AuthorEmail, int64(0)
+	author, err := app.store.Users.GetUserByEmail(c.Request().Context(), post.AuthorEmail)
+	switch {
+	case err == nil:
+		actor, authorID = author.Username, author.ID
+	case !errors.Is(err, store.ErrNotFound):
+		app.requestLogger(c).Warnw("failed to load post author for notification", "post_id", post.ID, "error", err)
+	}
+
+	return []store.TxFunc{func(ctx context.Context, tx *sql.Tx) error {
+		for _, id := range recipients {
+			if id == authorID {
+				continue
+			}
+			err := notify.Enqueue(ctx, tx, &notify.Notification{
+				Kind:   notify.KindMention,
+				UserID: id,
+				Data: map[string]string{
+					"actor":      actor,
+					"post_id":    strconv.FormatInt(post.ID, 10),
+					"post_title": post.Title,
+					"excerpt":    excerpt(post.Content, mentionExcerptLength),
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// excerpt shortens text to at most n runes, marking the cut with an ellipsis.
+func excerpt(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly ten", 11, "exactly ten"},
+		{"héllo wörld again", 8, "héllo w…"},
+		{"cut at space here", 7, "cut at…"},
+	}
+	for _, tt := range tests {
+		if got := excerpt(tt.text, tt.n); got != tt.want {
+			t.Errorf("excerpt(%q, %d): expected %q, got %q", tt.text, tt.n, tt.want, got)
+		}
+	}
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
+	"devops/internal/content"
 	"devops/internal/store"
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
-	"io"
 	"net/http"
-	"os"
 	"strconv"
+	"unicode/utf8"
 )
 
 type postKey string
@@ -16,45 +17,83 @@ type postKey string
 const path = "/app/public"
 const postCtx postKey = "post"
 
+// CreatePostPayload creates a post. Content is limited to
+// posts.max_content_length characters (10000 by default).
 type CreatePostPayload struct {
 	Title   string `json:"title" validate:"required,min=1,max=100"`
-	Content string `json:"content" validate:"required,min=1,max=1000"`
+	Content string `json:"content" validate:"required,min=1"`
+	Format  string `json:"format" validate:"omitempty,oneof=plain markdown" enums:"plain,markdown" default:"plain"`
+}
+
+// PostDetail is the single-post representation: the post with its author,
+// hashtags and image URLs keyed by variant. Only the original upload is
+// stored today, so "original" is the only variant. There are no comments,
+// likes or views to count yet, so counts are left out until one exists.
+type PostDetail struct {
+	*store.Post
+	Author PostAuthor        `json:"author"`
+	Tags   []string          `json:"tags"`
+	Images map[string]string `json:"images,omitempty"`
+}
+
+type PostAuthor struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
 }
 
 type EditPostPayload struct {
-	Content   string `json:"content" validate:"required,min=1,max=1000"`
+	Content   string `json:"content" validate:"required,min=1"`
+	Format    string `json:"format,omitempty" validate:"omitempty,oneof=plain markdown" enums:"plain,markdown"`
 	ImagePath string `json:"image,omitempty" validate:"min=1,max=1000"`
 }
 
 // @Summary Create a new createPost
-// @Description Create a new post
+// @Description Create a new post authored by the signed-in user
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Param payload body CreatePostPayload true "Post data"
+// @Param Idempotency-Key header string false "Unique key that makes retries of this request safe"
 // @Success 201 {object} store.Post
-// @Failure 400 {object} map[string]string "Invalid request format"
-// @Failure 422 {object} map[string]string "Validation error"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} Problem "Invalid request format"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 403 {object} Problem "User is banned"
+// @Failure 409 {object} Problem "A request with the same Idempotency-Key is in progress"
+// @Failure 422 {object} Problem "Validation error or Idempotency-Key reused with a different body"
+// @Failure 500 {object} Problem "Internal server error"
+// @Failure 429 {object} Problem "Too many requests"
 // @Router /post [post]
 func (app *application) createPost(c echo.Context) error {
 	var req CreatePostPayload
 	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request format"})
+		return badRequest("Invalid request format")
 	}
 	if err := Validate.Struct(req); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return validationFailed(err)
+	}
+	if err := app.checkContentLength(req.Content); err != nil {
+		return err
 	}
 
 	post := &store.Post{
 		Title:       req.Title,
 		Content:     req.Content,
-		AuthorEmail: os.Getenv("email"),
+		Format:      req.Format,
+		AuthorEmail: app.getAccountFromContext(c).Email,
+	}
+	if err := app.prepareContent(c.Request().Context(), post); err != nil {
+		return err
 	}
 
-	if err := app.store.Posts.Create(c.Request().Context(), post); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create post"})
+	if err := app.store.Posts.Create(c.Request().Context(), post, app.notifyMentions(c, post, store.Entities{})...); err != nil {
+		return internalError("Failed to create post", err)
 	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditPostCreate,
+		TargetType: store.TargetPost,
+		TargetID:   strconv.FormatInt(post.ID, 10),
+		Diff:       app.auditDiff(nil, post),
+	})
 	return c.JSON(http.StatusCreated, post)
 
 }
@@ -64,123 +103,219 @@ func (app *application) createPost(c echo.Context) error {
 // @Tags posts
 // @Accept json
 // @Produce json
+// @Param If-None-Match header string false "ETag of the cached list"
+// @Param If-Modified-Since header string false "Last-Modified of the cached list"
 // @Success 200 {array} store.Post
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Success 304 "Not Modified"
+// @Header 200 {string} ETag "List version"
+// @Header 200 {string} Last-Modified "Most recent post update"
+// @Failure 500 {object} Problem "Internal server error"
 // @Router  /post [get]
 func (app *application) getPosts(c echo.Context) error {
 	posts, err := app.store.Posts.GetList(c.Request().Context())
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve posts"})
+		return internalError("Failed to retrieve posts", err)
+	}
+	if notModified(c, postListETag(posts), postListLastModified(posts), postListCacheControl) {
+		return c.NoContent(http.StatusNotModified)
 	}
 	return c.JSON(http.StatusOK, posts)
 }
 
+// @Summary Get a post
+// @Description Retrieve a single visible post
+// @Tags posts
+// @Produce json
+// @Param id path int true "Post id"
+// @Param If-None-Match header string false "ETag of the cached post"
+// @Param If-Modified-Since header string false "Last-Modified of the cached post"
+// @Success 200 {object} PostDetail
+// @Success 304 "Not Modified"
+// @Header 200 {string} ETag "Post revision"
+// @Header 200 {string} Last-Modified "Time of the last edit"
+// @Failure 400 {object} Problem "Invalid post ID"
+// @Failure 404 {object} Problem "Post not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /post/{id} [get]
+func (app *application) getPost(c echo.Context) error {
+	post := app.getPostFromContext(c)
+	if post.Hidden {
+		return notFound("Post not found")
+	}
+	if notModified(c, postETag(post), post.UpdatedAt, postCacheControl) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	detail := PostDetail{
+		Post:   post,
+		Author: PostAuthor{Email: post.AuthorEmail},
+		Tags:   extractTags(post.Content),
+	}
+	author, err := app.store.Users.GetUserByEmail(c.Request().Context(), post.AuthorEmail)
+	switch {
+	case err == nil:
+		detail.Author.Username = author.Username
+	case !errors.Is(err, store.ErrNotFound):
+		return internalError("Failed to retrieve author", err)
+	}
+	if post.PhotoURL != "" {
+		detail.Images = map[string]string{"original": "/public/" + post.PhotoURL}
+	}
+	return c.JSON(http.StatusOK, detail)
+}
+
 // @Summary Edit an existing post
-// @Description Edit an existing post
+// @Description Edit one of the signed-in user's posts. Moderators can edit any post.
 // @Tags posts
 // @Accept multipart/form-data
 // @Produce json
 // @Param post body EditPostPayload true "Post data"
 // @Param id path int true "Post id"
 // @Success 200 {object} store.Post
-// @Failure 400 {object} map[string]string "Invalid request format"
-// @Failure 422 {object} map[string]string "Validation error"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} Problem "Invalid request format"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 403 {object} Problem "User is banned or not the author"
+// @Failure 404 {object} Problem "Post not found"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Failure 429 {object} Problem "Too many requests"
 // @Router /post/{id} [patch]
 func (app *application) editPost(c echo.Context) error {
-	post, err := app.getPostFromContext(c)
-	if err != nil {
-		switch {
-		case errors.Is(err, store.ErrNotFound):
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "Post not found"})
-		default:
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve post"})
-		}
-	}
+	post := app.getPostFromContext(c)
+	before := *post
 	if err := c.Request().ParseMultipartForm(10 << 20); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid form data"})
+		return badRequest("Invalid form data")
 	}
 
-	content := c.FormValue("content")
-	if content != "" {
-		post.Content = content
+	text, format := c.FormValue("content"), c.FormValue("format")
+	form := struct {
+		Format string `json:"format" validate:"omitempty,oneof=plain markdown"`
+	}{format}
+	if err := Validate.Struct(form); err != nil {
+		return validationFailed(err)
+	}
+	if err := app.checkContentLength(text); err != nil {
+		return err
+	}
+	if text != "" || format != "" {
+		if text != "" {
+			post.Content = text
+		}
+		if format != "" {
+			post.Format = format
+		}
+		if err := app.prepareContent(c.Request().Context(), post); err != nil {
+			return err
+		}
 	}
 
 	file, err := c.FormFile("photo")
 	if err == nil {
 		src, err := file.Open()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to open uploaded file"})
+			return internalError("Failed to open uploaded file", err)
 		}
 		defer src.Close()
 
-		// Ensure directory exists
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			if err := os.MkdirAll(path, 0755); err != nil {
-				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create upload directory"})
-			}
-		}
-
-		// Save with unique filename
-		dstPath := fmt.Sprintf("%s/%s", path, file.Filename)
-		dst, err := os.Create(dstPath)
+		// Save under a content-hashed name so it can be cached forever.
+		name, err := saveUpload(path, file.Filename, src)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save file"})
-		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, src); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to write file"})
+			return internalError("Failed to save file", err)
 		}
 
 		// Store relative path for serving
-		post.PhotoURL = file.Filename
+		post.PhotoURL = name
 	}
 
 	// Save changes to DB
-	if err = app.store.Posts.Edit(c.Request().Context(), post); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update post"})
+	if err = app.store.Posts.Edit(c.Request().Context(), post, app.notifyMentions(c, post, before.Entities)...); err != nil {
+		return internalError("Failed to update post", err)
 	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditPostEdit,
+		TargetType: store.TargetPost,
+		TargetID:   strconv.FormatInt(post.ID, 10),
+		Diff:       app.auditDiff(&before, post),
+	})
 
 	return c.JSON(http.StatusOK, post)
 
 }
 
 // @Summary Delete an existing post
-// @Description Delete an existing post
+// @Description Delete one of the signed-in user's posts. Moderators can delete any post.
 // @Tags posts
 // @Accept json
 // @Produce json
 // @Param id path int true "Post id"
 // @Success 204 "No Content"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Failure 400 {object} Problem "Invalid post ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 403 {object} Problem "User is banned or not the author"
+// @Failure 404 {object} Problem "Post not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Failure 429 {object} Problem "Too many requests"
 // @Router /post/{id} [delete]
 func (app *application) deletePost(c echo.Context) error {
-	post, err := app.getPostFromContext(c)
-	if err != nil {
-		switch {
-		case errors.Is(err, store.ErrNotFound):
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "Post not found"})
-		default:
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve post"})
-		}
-	}
+	post := app.getPostFromContext(c)
 
-	if err = app.store.Posts.Delete(c.Request().Context(), post.ID); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete post"})
+	if err := app.store.Posts.Delete(c.Request().Context(), post.ID); err != nil {
+		return internalError("Failed to delete post", err)
 	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditPostDelete,
+		TargetType: store.TargetPost,
+		TargetID:   strconv.FormatInt(post.ID, 10),
+		Diff:       app.auditDiff(post, nil),
+	})
 	return c.NoContent(http.StatusNoContent)
 }
 
-func (app *application) getPostFromContext(c echo.Context) (*store.Post, error) {
-	postID := c.Param("id")
-	id, err := strconv.ParseInt(postID, 10, 64)
-	if err != nil {
-		return nil, err
+// extractTags returns the distinct lower-cased hashtags in text, in order
+// of first use. Unlike post.Entities it also covers posts written before
+// entities were stored.
+func extractTags(text string) []string {
+	return content.Tags(content.Parse(text))
+}
+
+// prepareContent derives everything stored alongside post.Content: the
+// rendered HTML and the entities, with mentions resolved to users.
+func (app *application) prepareContent(ctx context.Context, post *store.Post) error {
+	if post.Format == "" {
+		post.Format = content.FormatPlain
 	}
-	post, err := app.store.Posts.GetByID(c.Request().Context(), id)
+	rendered, err := content.Render(post.Format, post.Content)
 	if err != nil {
-		return nil, err
+		return internalError("Failed to render content", err)
+	}
+	entities := content.Parse(post.Content)
+	if err := content.Resolve(ctx, app.store.Users, &entities); err != nil {
+		return internalError("Failed to resolve mentions", err)
+	}
+	post.ContentHTML, post.Entities = rendered, entities
+	return nil
+}
+
+// checkContentLength enforces posts.max_content_length, which is
+// configurable and so cannot be a validate tag.
+func (app *application) checkContentLength(text string) error {
+	limit := app.config.Posts.MaxContentLength
+	if utf8.RuneCountInString(text) <= limit {
+		return nil
+	}
+	return &APIError{
+		Status: http.StatusUnprocessableEntity,
+		Detail: "The request body failed validation",
+		Errors: []FieldError{{
+			Field:   "content",
+			Code:    "max",
+			Message: fmt.Sprintf("content must be at most %d characters", limit),
+		}},
 	}
-	return post, nil
+}
+
+// getPostFromContext returns the post loaded by PostContextMiddleware.
+func (app *application) getPostFromContext(c echo.Context) *store.Post {
+	post, _ := c.Request().Context().Value(postCtx).(*store.Post)
+	return post
 }
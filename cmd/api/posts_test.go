@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"devops/internal/config"
+	"devops/internal/store"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractTags(t *testing.T) {
+	got := extractTags("Shipping #Go today #devops, more #go soon. Not a tag: a#b? #")
+	want := []string{"go", "devops"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := extractTags("no tags"); got == nil || len(got) != 0 {
+		t.Errorf("Expected an empty, non-nil slice, got %#v", got)
+	}
+}
+
+func TestContentLimits(t *testing.T) {
+	app := &application{
+		config: &config.Config{Posts: config.Posts{MaxContentLength: 5}},
+		logger: zap.NewNop().Sugar(),
+	}
+	e := echo.New()
+	e.HTTPErrorHandler = app.httpErrorHandler
+	e.POST("/post", app.createPost)
+	e.PATCH("/post/1", func(c echo.Context) error {
+		ctx := context.WithValue(c.Request().Context(), postCtx, &store.Post{ID: 1})
+		c.SetRequest(c.Request().WithContext(ctx))
+		return app.editPost(c)
+	})
+
+	form := func(fields map[string]string) (io.Reader, string) {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		for k, v := range fields {
+			w.WriteField(k, v)
+		}
+		w.Close()
+		return &body, w.FormDataContentType()
+	}
+	longEdit, longEditType := form(map[string]string{"content": "héllo!"})
+	badFormat, badFormatType := form(map[string]string{"format": "html"})
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        io.Reader
+		contentType string
+		field       string
+		message     string
+	}{
+		{"create over limit", http.MethodPost, "/post", strings.NewReader(`{"title":"t","content":"héllo!"}`),
+			echo.MIMEApplicationJSON, "content", "content must be at most 5 characters"},
+		{"create unknown format", http.MethodPost, "/post", strings.NewReader(`{"title":"t","content":"hi","format":"html"}`),
+			echo.MIMEApplicationJSON, "format", "format must be one of: plain, markdown"},
+		{"edit over limit", http.MethodPatch, "/post/1", longEdit, longEditType,
+			"content", "content must be at most 5 characters"},
+		{"edit unknown format", http.MethodPatch, "/post/1", badFormat, badFormatType,
+			"format", "format must be one of: plain, markdown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, tt.body)
+			req.Header.Set(echo.HeaderContentType, tt.contentType)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("Expected 422, got %d: %s", rec.Code, rec.Body)
+			}
+			var problem Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if len(problem.Errors) != 1 || problem.Errors[0].Field != tt.field || problem.Errors[0].Message != tt.message {
+				t.Errorf("Expected %s error %q, got %+v", tt.field, tt.message, problem.Errors)
+			}
+		})
+	}
+}
+
+func TestPostAuthorMiddleware(t *testing.T) {
+	app := &application{logger: zap.NewNop().Sugar()}
+	post := &store.Post{ID: 1, AuthorEmail: "author@example.com"}
+
+	tests := []struct {
+		name    string
+		account *store.User
+		want    int
+	}{
+		{"author", &store.User{Email: "author@example.com", Role: store.RoleUser}, http.StatusNoContent},
+		{"other user", &store.User{Email: "other@example.com", Role: store.RoleUser}, http.StatusForbidden},
+		{"moderator", &store.User{Email: "mod@example.com", Role: store.RoleModerator}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.HTTPErrorHandler = app.httpErrorHandler
+			e.DELETE("/post/1", func(c echo.Context) error {
+				return c.NoContent(http.StatusNoContent)
+			}, func(next echo.HandlerFunc) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					ctx := context.WithValue(c.Request().Context(), postCtx, post)
+					ctx = context.WithValue(ctx, accountCtx, tt.account)
+					c.SetRequest(c.Request().WithContext(ctx))
+					return next(c)
+				}
+			}, app.PostAuthorMiddleware)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/post/1", nil))
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
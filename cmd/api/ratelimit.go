@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"devops/internal/auth"
+	"devops/internal/config"
+	"devops/internal/metrics"
+	"devops/internal/ratelimit"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const rateLimitSweepInterval = time.Minute
+
+// rateLimitExempt routes are polled by infrastructure and never limited.
+var rateLimitExempt = map[string]bool{
+	"/v1/health":       true,
+	"/v1/health/live":  true,
+	"/v1/health/ready": true,
+}
+
+// newRateLimiter builds the configured backend and parses every policy. It
+// returns a nil store when rate limiting is disabled.
+func newRateLimiter(cfg config.RateLimit, db *sql.DB) (ratelimit.Store, map[string]ratelimit.Policy, error) {
+	if !cfg.Enabled {
+		return nil, nil, nil
+	}
+	policies := make(map[string]ratelimit.Policy)
+	for name, raw := range cfg.Policies() {
+		p, err := ratelimit.ParsePolicy(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rate_limit.%s: %w", name, err)
+		}
+		policies[name] = p
+	}
+	switch cfg.Backend {
+	case "memory":
+		return ratelimit.NewMemory(), policies, nil
+	case "postgres":
+		return ratelimit.NewPostgres(db), policies, nil
+	default:
+		return nil, nil, fmt.Errorf("rate_limit.backend: unknown backend %q", cfg.Backend)
+	}
+}
+
+// rateLimit enforces the policies of group, "<group>_anonymous" keyed by
+// client IP and "<group>_user" keyed by the signed-in account. Groups stack,
+// so a request may be charged against several buckets; the headers describe
+// whichever has the fewest requests left.
+func (app *application) rateLimit(group string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if app.limiter == nil || req.Method == http.MethodOptions || rateLimitExempt[c.Path()] {
+				return next(c)
+			}
+
+			kind, subject := "user", auth.SessionSubject(req)
+			if subject == "" {
+				kind, subject = "anonymous", c.RealIP()
+			}
+			policy := app.rateLimits[group+"_"+kind]
+
+			res, err := app.limiter.Take(req.Context(), group+":"+kind+":"+subject, policy)
+			if err != nil {
+				// Fail open: a limiter outage should not take the API down with it.
+				app.requestLogger(c).Errorw("rate limiter unavailable", "group", group, "error", err)
+				return next(c)
+			}
+			setRateLimitHeaders(c.Response().Header(), policy, res)
+
+			if !res.Allowed {
+				metrics.RateLimited.WithLabelValues(group, kind).Inc()
+				retryAfter := strconv.Itoa(ceilSeconds(res.RetryAfter))
+				c.Response().Header().Set(echo.HeaderRetryAfter, retryAfter)
+				return tooManyRequests("Rate limit exceeded, retry in " + retryAfter + "s")
+			}
+			return next(c)
+		}
+	}
+}
+
+// setRateLimitHeaders writes the RateLimit-* headers from the IETF
+// httpapi-ratelimit-headers draft unless an outer group has already reported
+// a tighter limit.
+func setRateLimitHeaders(h http.Header, p ratelimit.Policy, res ratelimit.Result) {
+	if prev := h.Get("RateLimit-Remaining"); prev != "" {
+		if n, err := strconv.Atoi(prev); err == nil && n <= res.Remaining {
+			return
+		}
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, ceilSeconds(p.Period)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// sweepRateLimits periodically drops idle buckets. A bucket untouched for
+// the longest policy period is full under every policy, so dropping it does
+// not change any limit.
+func (app *application) sweepRateLimits(ctx context.Context) {
+	var idle time.Duration
+	for _, p := range app.rateLimits {
+		idle = max(idle, p.Period)
+	}
+
+	ticker := time.NewTicker(rateLimitSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := app.limiter.Sweep(ctx, idle); err != nil {
+				app.logger.Errorw("failed to sweep rate limit buckets", "error", err)
+			}
+		}
+	}
+}
+
+// ipExtractor resolves the client IP used for anonymous rate limits and
+// audit events. X-Forwarded-For is only trusted from configured proxies;
+// otherwise any client could pick its own bucket.
+func (app *application) ipExtractor() echo.IPExtractor {
+	if len(app.config.HTTP.TrustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	opts := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range app.config.HTTP.TrustedProxies {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			opts = append(opts, echo.TrustIPRange(ipNet))
+		}
+	}
+	return echo.ExtractIPFromXFFHeader(opts...)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"devops/internal/config"
+	"devops/internal/ratelimit"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	app := &application{
+		config:  &config.Config{},
+		logger:  zap.NewNop().Sugar(),
+		limiter: ratelimit.NewMemory(),
+		rateLimits: map[string]ratelimit.Policy{
+			"test_anonymous": {Limit: 2, Period: time.Minute},
+		},
+	}
+	e := echo.New()
+	e.IPExtractor = app.ipExtractor()
+	e.HTTPErrorHandler = app.httpErrorHandler
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, app.rateLimit("test"))
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.9")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		rec := do("192.0.2.1:1234")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200, got %d", i+1, rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("Request %d: expected RateLimit-Remaining %s, got %q", i+1, remaining, got)
+		}
+	}
+
+	rec := do("192.0.2.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get(echo.HeaderRetryAfter); got != "30" {
+		t.Errorf("Expected Retry-After 30, got %q", got)
+	}
+	if got := rec.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+		t.Errorf("Expected RateLimit-Policy 2;w=60, got %q", got)
+	}
+
+	// X-Forwarded-For is not trusted without configured proxies, so a
+	// different peer gets its own bucket despite the same header.
+	if rec := do("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("Expected other client to be allowed, got %d", rec.Code)
+	}
+}
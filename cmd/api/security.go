@@ -0,0 +1,179 @@
+package main
+
+import (
+	"devops/internal/metrics"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	cspReportPath    = "/v1/csp-report"
+	maxCSPReportSize = 64 << 10
+)
+
+// corsMiddleware builds the CORS policy from configuration. Origins are
+// matched by originAllowed rather than echo's pattern support so that a
+// wildcard can only ever match a subdomain of the configured host.
+func (app *application) corsMiddleware() echo.MiddlewareFunc {
+	cfg := app.config.CORS
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOriginFunc: func(origin string) (bool, error) {
+			return originAllowed(cfg.AllowOrigins, origin), nil
+		},
+		AllowMethods: cfg.AllowMethods,
+		AllowHeaders: cfg.AllowHeaders,
+		ExposeHeaders: []string{
+			echo.HeaderRetryAfter, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+			headerIdempotentReplayed, "ETag",
+		},
+		AllowCredentials: true,
+		MaxAge:           int(cfg.MaxAge.Seconds()),
+	})
+}
+
+// originAllowed reports whether origin matches one of the allowed entries.
+// Entries are either exact origins or "scheme://*.domain[:port]" patterns.
+func originAllowed(allowed []string, origin string) bool {
+	o, err := url.Parse(origin)
+	if err != nil || o.Scheme == "" || o.Host == "" || o.Path != "" || o.User != nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if entry == "*" {
+			return true
+		}
+		a, err := url.Parse(strings.Replace(entry, "*.", "wildcard.", 1))
+		if err != nil || !strings.EqualFold(a.Scheme, o.Scheme) || a.Port() != o.Port() {
+			continue
+		}
+		if !strings.Contains(entry, "*.") {
+			if strings.EqualFold(a.Hostname(), o.Hostname()) {
+				return true
+			}
+			continue
+		}
+		suffix := strings.TrimPrefix(a.Hostname(), "wildcard")
+		host := strings.ToLower(o.Hostname())
+		if strings.HasSuffix(host, strings.ToLower(suffix)) && len(host) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// securityHeadersMiddleware sets CSP, HSTS (production only), nosniff,
+// framing and referrer headers. The CSP reports violations to cspReportPath
+// and can run in report-only mode while a policy is being rolled out.
+func (app *application) securityHeadersMiddleware() echo.MiddlewareFunc {
+	cfg := app.config.Security
+	csp := cfg.ContentSecurityPolicy
+	if csp != "" && !strings.Contains(csp, "report-uri") {
+		csp = strings.TrimSuffix(strings.TrimSpace(csp), ";") + "; report-uri " + cspReportPath
+	}
+	hstsMaxAge := 0
+	if app.config.IsProd() {
+		hstsMaxAge = int(cfg.HSTSMaxAge.Seconds())
+	}
+
+	return middleware.SecureWithConfig(middleware.SecureConfig{
+		Skipper: func(c echo.Context) bool {
+			// Swagger UI relies on inline scripts and styles.
+			return strings.HasPrefix(c.Path(), "/v1/swagger")
+		},
+		ContentTypeNosniff:    "nosniff",
+		XFrameOptions:         "DENY",
+		HSTSMaxAge:            hstsMaxAge,
+		ContentSecurityPolicy: csp,
+		CSPReportOnly:         cfg.CSPReportOnly,
+		ReferrerPolicy:        cfg.ReferrerPolicy,
+	})
+}
+
+var knownCSPDirectives = map[string]bool{
+	"default-src": true, "script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "img-src": true,
+	"font-src": true, "connect-src": true, "media-src": true, "object-src": true,
+	"frame-src": true, "child-src": true, "worker-src": true, "manifest-src": true,
+	"frame-ancestors": true, "form-action": true, "base-uri": true,
+}
+
+// cspReport is the legacy report-uri body. Reporting API deliveries wrap the
+// same fields in a list of {"type": "csp-violation", "body": {...}}.
+type cspReport struct {
+	DocumentURI        string `json:"document-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	BlockedURI         string `json:"blocked-uri"`
+	Disposition        string `json:"disposition"`
+}
+
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		Disposition        string `json:"disposition"`
+	} `json:"body"`
+}
+
+// @Summary CSP violation report
+// @Description Receives Content-Security-Policy violation reports from browsers
+// @Tags security
+// @Accept application/csp-report
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid report"
+// @Router /csp-report [post]
+func (app *application) cspReportHandler(c echo.Context) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxCSPReportSize))
+	if err != nil {
+		return badRequest("Invalid report")
+	}
+
+	var reports []cspReport
+	var legacy struct {
+		Report cspReport `json:"csp-report"`
+	}
+	var batch []reportingAPIReport
+	switch {
+	case json.Unmarshal(body, &legacy) == nil && legacy.Report.DocumentURI != "":
+		reports = append(reports, legacy.Report)
+	case json.Unmarshal(body, &batch) == nil:
+		for _, r := range batch {
+			if r.Type != "csp-violation" {
+				continue
+			}
+			reports = append(reports, cspReport{
+				DocumentURI:        r.Body.DocumentURL,
+				EffectiveDirective: r.Body.EffectiveDirective,
+				BlockedURI:         r.Body.BlockedURL,
+				Disposition:        r.Body.Disposition,
+			})
+		}
+	default:
+		return badRequest("Invalid report")
+	}
+
+	for _, r := range reports {
+		directive := r.EffectiveDirective
+		if directive == "" {
+			directive, _, _ = strings.Cut(r.ViolatedDirective, " ")
+		}
+		if !knownCSPDirectives[directive] {
+			// Reports are unauthenticated; keep the label set bounded.
+			directive = "other"
+		}
+		metrics.CSPViolations.WithLabelValues(directive).Inc()
+		app.requestLogger(c).Warnw("csp violation",
+			"document_uri", r.DocumentURI,
+			"directive", directive,
+			"blocked_uri", r.BlockedURI,
+			"disposition", r.Disposition)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"http://localhost:5173", "https://*.example.com"}
+
+	cases := map[string]bool{
+		"http://localhost:5173":        true,
+		"http://localhost:3000":        false,
+		"https://app.example.com":      true,
+		"https://a.b.example.com":      true,
+		"https://example.com":          false,
+		"http://app.example.com":       false,
+		"https://app.example.com:8443": false,
+		"https://evilexample.com":      false,
+		"https://example.com.evil.com": false,
+		"https://app.example.com/path": false,
+		"null":                         false,
+	}
+	for origin, want := range cases {
+		if got := originAllowed(allowed, origin); got != want {
+			t.Errorf("Expected originAllowed(%q) = %v, got %v", origin, want, got)
+		}
+	}
+}
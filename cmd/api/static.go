@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	staticCacheControl    = "public, max-age=300"
+)
+
+var (
+	// hashedAsset matches names produced by saveUpload.
+	hashedAsset = regexp.MustCompile(`^[0-9a-f]{32}(\.[a-z0-9]{1,8})?$`)
+	uploadExt   = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+)
+
+// saveUpload writes src into dir under the first 128 bits of its SHA-256 and
+// the original extension. A name therefore always refers to the same bytes,
+// which lets /public serve it as immutable.
+func saveUpload(dir, filename string, src io.Reader) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), src); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	name := hex.EncodeToString(h.Sum(nil))[:32]
+	if ext := strings.ToLower(filepath.Ext(filename)); uploadExt.MatchString(ext) {
+		name += ext
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// staticCacheMiddleware marks content-hashed files as immutable. Anything
+// else under /public, such as bundled defaults, gets a short max-age. The
+// route is /public*, so the wildcard keeps the leading slash.
+func staticCacheMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cacheControl := staticCacheControl
+		if hashedAsset.MatchString(strings.TrimPrefix(c.Param("*"), "/")) {
+			cacheControl = immutableCacheControl
+		}
+		c.Response().Header().Set(echo.HeaderCacheControl, cacheControl)
+		return next(c)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveUploadUsesContentHash(t *testing.T) {
+	dir := t.TempDir()
+
+	name, err := saveUpload(dir, "../../Cat.PNG", strings.NewReader("meow"))
+	if err != nil {
+		t.Fatalf("saveUpload returned error: %v", err)
+	}
+	if !hashedAsset.MatchString(name) || !strings.HasSuffix(name, ".png") {
+		t.Errorf("Expected a content-hashed .png name, got %q", name)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, name)); string(data) != "meow" {
+		t.Errorf("Expected file content to be saved, got %q", data)
+	}
+
+	again, _ := saveUpload(dir, "other.png", strings.NewReader("meow"))
+	if again != name {
+		t.Errorf("Expected identical content to get the same name, got %q and %q", name, again)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected temporary files to be cleaned up, found %d entries", len(entries))
+	}
+}
+
+func TestStaticCacheMiddleware(t *testing.T) {
+	e := echo.New()
+	e.GET("/public*", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, staticCacheMiddleware)
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/public/0123456789abcdef0123456789abcdef.png", immutableCacheControl},
+		{"/public/0123456789abcdef0123456789abcdef", immutableCacheControl},
+		{"/public/default.png", staticCacheControl},
+		{"/public/nested/0123456789abcdef0123456789abcdef.png", staticCacheControl},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if got := rec.Header().Get(echo.HeaderCacheControl); got != tc.want {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tc.path, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"devops/internal/auth"
+	"devops/internal/events"
+	"devops/internal/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	postStreamPath = "/v1/post/stream"
+
+	// streamEventReset tells a resuming client that events were lost and it
+	// has to reload the feed.
+	streamEventReset = "reset"
+	maxStreamBacklog = 1000
+	wsWriteTimeout   = 10 * time.Second
+
+	// streamEventNotification carries a new entry of the user's inbox.
+	streamEventNotification = "notification"
+)
+
+// @Summary Stream post changes
+// @Description Pushes created, edited and deleted post events as Server-Sent Events, or as JSON messages when the request is a WebSocket upgrade. Reconnecting clients resume after Last-Event-ID (or last_event_id); a "reset" event means the feed has to be reloaded. Signed-in clients also receive "notification" events for new inbox entries; these are not replayed on resume.
+// @Tags posts
+// @Produce text/event-stream
+// @Param Last-Event-ID header int false "Id of the last event received"
+// @Param last_event_id query int false "Id of the last event received, for clients that cannot set headers"
+// @Success 200 {object} store.PostEvent "Event stream"
+// @Failure 400 {object} Problem "Invalid Last-Event-ID"
+// @Router /post/stream [get]
+func (app *application) streamPosts(c echo.Context) error {
+	lastID, err := parseLastEventID(c)
+	if err != nil {
+		return badRequest("Invalid Last-Event-ID")
+	}
+	var userID int64
+	if account := app.streamAccount(c); account != nil {
+		userID = account.ID
+	}
+	if websocket.IsWebSocketUpgrade(c.Request()) {
+		return app.streamPostsWebSocket(c, lastID, userID)
+	}
+
+	res := c.Response()
+	// The stream outlives the server's write timeout by design.
+	_ = http.NewResponseController(res).SetWriteDeadline(time.Time{})
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-store")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprint(res, "retry: 2000\n\n")
+	res.Flush()
+
+	sink := streamSink{
+		event: func(event *store.PostEvent) error {
+			var err error
+			if event.Type == streamEventReset {
+				_, err = fmt.Fprint(res, "event: reset\ndata: {}\n\n")
+			} else {
+				var data []byte
+				if data, err = json.Marshal(event); err == nil {
+					_, err = fmt.Fprintf(res, "id: %d\nevent: post.%s\ndata: %s\n\n", event.ID, event.Type, data)
+				}
+			}
+			res.Flush()
+			return err
+		},
+		// Notifications carry no SSE id so that they do not move the
+		// client's resume point in the post event log.
+		notification: func(n *store.Notification) error {
+			data, err := json.Marshal(n)
+			if err == nil {
+				_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", streamEventNotification, data)
+			}
+			res.Flush()
+			return err
+		},
+		heartbeat: func() error {
+			_, err := fmt.Fprint(res, ": ping\n\n")
+			res.Flush()
+			return err
+		},
+	}
+
+	if err := app.relayPostEvents(c.Request().Context(), lastID, userID, sink); err != nil {
+		app.requestLogger(c).Warnw("post stream ended", "error", err)
+	}
+	return nil
+}
+
+func (app *application) streamPostsWebSocket(c echo.Context, lastID, userID int64) error {
+	upgrader := websocket.Upgrader{
+		// Browsers send cookies with cross-site upgrades, so only allow the
+		// origins trusted for CORS.
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get(echo.HeaderOrigin)
+			return origin == "" || originAllowed(app.config.CORS.AllowOrigins, origin)
+		},
+	}
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// Upgrade has already written the error response.
+		app.requestLogger(c).Warnw("websocket upgrade failed", "error", err)
+		return nil
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	// The stream is one-way; reading is only needed to process pongs and
+	// notice when the client goes away.
+	timeout := 2 * app.config.Stream.Heartbeat
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	sink := streamSink{
+		event: func(event *store.PostEvent) error {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if event.Type == streamEventReset {
+				return conn.WriteJSON(map[string]string{"type": streamEventReset})
+			}
+			return conn.WriteJSON(event)
+		},
+		notification: func(n *store.Notification) error {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			return conn.WriteJSON(map[string]any{"type": streamEventNotification, "notification": n})
+		},
+		heartbeat: func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		},
+	}
+
+	err = app.relayPostEvents(ctx, lastID, userID, sink)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		app.requestLogger(c).Warnw("post stream ended", "error", err)
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+	return nil
+}
+
+// streamSink writes one kind of stream message each.
+type streamSink struct {
+	event        func(*store.PostEvent) error
+	notification func(*store.Notification) error
+	heartbeat    func() error
+}
+
+// relayPostEvents replays the events after lastID and then forwards live
+// events until ctx is done, the broker drops the client for falling behind,
+// or the server shuts down. Subscribing before reading the backlog means no
+// event can fall between the two; duplicates are skipped by id. When userID
+// is set, that user's new notifications are forwarded as well.
+func (app *application) relayPostEvents(ctx context.Context, lastID, userID int64, sink streamSink) error {
+	sub := app.broker.Subscribe()
+	defer sub.Close()
+
+	var notifications <-chan *store.Notification
+	if userID != 0 {
+		inbox := app.inbox.Subscribe(userID)
+		defer inbox.Close()
+		notifications = inbox.C
+	}
+
+	if lastID > 0 {
+		backlog, err := app.postEventsSince(ctx, lastID)
+		if err != nil {
+			return err
+		}
+		if backlog == nil {
+			if err := sink.event(&store.PostEvent{Type: streamEventReset}); err != nil {
+				return err
+			}
+		}
+		for _, event := range backlog {
+			if err := sink.event(event); err != nil {
+				return err
+			}
+			lastID = event.ID
+		}
+	}
+
+	ticker := time.NewTicker(app.config.Stream.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := sink.heartbeat(); err != nil {
+				return err
+			}
+		case n, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
+			if err := sink.notification(n); err != nil {
+				return err
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				if sub.Lagged() {
+					return errors.New("client fell behind")
+				}
+				return nil
+			}
+			if event.ID <= lastID {
+				continue
+			}
+			if err := sink.event(event); err != nil {
+				return err
+			}
+			lastID = event.ID
+		}
+	}
+}
+
+// streamAccount returns the signed-in user of a stream request, or nil. The
+// stream is public, so a missing or invalid session only means that no
+// notifications are pushed.
+func (app *application) streamAccount(c echo.Context) *store.User {
+	if auth.SessionSubject(c.Request()) == "" {
+		return nil
+	}
+	user, err := auth.GetUserFromSession(c.Request())
+	if err != nil || user.Email == "" {
+		return nil
+	}
+	account, err := app.store.Users.GetUserByEmail(c.Request().Context(), user.Email)
+	if err != nil || account.Banned {
+		return nil
+	}
+	return account
+}
+
+// postEventsSince returns the events after lastID. It returns nil when they
+// can no longer be replayed, because they were pruned or there are too many.
+func (app *application) postEventsSince(ctx context.Context, lastID int64) ([]*store.PostEvent, error) {
+	oldest, newest, err := app.store.PostEvents.Bounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if newest == 0 || lastID < oldest-1 || lastID > newest {
+		return nil, nil
+	}
+
+	backlog := []*store.PostEvent{}
+	for {
+		events, err := app.store.PostEvents.ListSince(ctx, lastID, maxStreamBacklog)
+		if err != nil {
+			return nil, err
+		}
+		backlog = append(backlog, events...)
+		if len(backlog) > maxStreamBacklog {
+			return nil, nil
+		}
+		if len(events) < maxStreamBacklog {
+			return backlog, nil
+		}
+		lastID = events[len(events)-1].ID
+	}
+}
+
+func parseLastEventID(c echo.Context) (int64, error) {
+	raw := c.Request().Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = c.QueryParam("last_event_id")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id < 0 {
+		return 0, errors.New("invalid event id")
+	}
+	return id, nil
+}
+
+// listenInbox relays new notifications from Postgres into the inbox,
+// reconnecting until the application stops.
+func (app *application) listenInbox(ctx context.Context) {
+	for {
+		err := events.ListenInbox(ctx, app.config.DB.Addr, app.inbox, app.logger)
+		if ctx.Err() != nil {
+			return
+		}
+		app.logger.Errorw("notification listener stopped, restarting", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// listenPostEvents relays post events from Postgres into the broker,
+// reconnecting until the application stops. It starts after the newest
+// event, which streams replay themselves, and each restart resumes after
+// the last event relayed so that events written while the listener was
+// down still reach live subscribers.
+func (app *application) listenPostEvents(ctx context.Context) {
+	var last int64
+	started := false
+	for {
+		var err error
+		if !started {
+			_, last, err = app.store.PostEvents.Bounds(ctx)
+			started = err == nil
+		}
+		if started {
+			last, err = events.Listen(ctx, app.config.DB.Addr, app.store.PostEvents, app.broker, last, app.logger)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		app.logger.Errorw("post event listener stopped, restarting", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"devops/internal/config"
+	"devops/internal/events"
+	"devops/internal/store"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryPostEvents struct {
+	events []*store.PostEvent
+}
+
+func (m *memoryPostEvents) ListSince(_ context.Context, afterID int64, limit int) ([]*store.PostEvent, error) {
+	var out []*store.PostEvent
+	for _, ev := range m.events {
+		if ev.ID > afterID && len(out) < limit {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryPostEvents) Bounds(context.Context) (int64, int64, error) {
+	if len(m.events) == 0 {
+		return 0, 0, nil
+	}
+	return m.events[0].ID, m.events[len(m.events)-1].ID, nil
+}
+
+func (m *memoryPostEvents) DeleteBefore(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestStreamPostsResume(t *testing.T) {
+	log := &memoryPostEvents{events: []*store.PostEvent{
+		{ID: 5, Type: store.PostEventCreated, PostID: 1, Post: []byte(`{"id":1}`)},
+		{ID: 6, Type: store.PostEventEdited, PostID: 1, Post: []byte(`{"id":1}`)},
+		{ID: 7, Type: store.PostEventDeleted, PostID: 1, Post: []byte(`{"id":1}`)},
+	}}
+	// A closed broker ends the stream once the backlog has been replayed.
+	broker := events.NewBroker(1)
+	broker.Close()
+	app := &application{
+		config: &config.Config{Stream: config.Stream{Heartbeat: time.Minute}},
+		logger: zap.NewNop().Sugar(),
+		store:  &store.Storage{PostEvents: log},
+		broker: broker,
+	}
+	e := echo.New()
+	e.HTTPErrorHandler = app.httpErrorHandler
+	e.GET("/stream", app.streamPosts)
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []string
+		wantStatus  int
+	}{
+		{"fresh client gets no backlog", "", nil, http.StatusOK},
+		{"resume replays later events", "5", []string{"id: 6\nevent: post.edited", "id: 7\nevent: post.deleted"}, http.StatusOK},
+		{"pruned history resets", "2", []string{"event: reset"}, http.StatusOK},
+		{"unknown future id resets", "9", []string{"event: reset"}, http.StatusOK},
+		{"invalid id", "abc", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			body := rec.Body.String()
+			for _, frame := range tt.want {
+				if !strings.Contains(body, frame) {
+					t.Errorf("Expected %q in stream, got %q", frame, body)
+				}
+			}
+			if tt.wantStatus == http.StatusOK && len(tt.want) == 0 && strings.Contains(body, "id: ") {
+				t.Errorf("Expected no events, got %q", body)
+			}
+		})
+	}
+}
+
+func TestRelayForwardsOwnNotifications(t *testing.T) {
+	app := &application{
+		config: &config.Config{Stream: config.Stream{Heartbeat: time.Minute}},
+		logger: zap.NewNop().Sugar(),
+		store:  &store.Storage{PostEvents: &memoryPostEvents{}},
+		broker: events.NewBroker(4),
+		inbox:  events.NewInbox(4),
+	}
+
+	got := make(chan *store.Notification, 4)
+	sink := streamSink{
+		event:        func(*store.PostEvent) error { return nil },
+		notification: func(n *store.Notification) error { got <- n; return nil },
+		heartbeat:    func() error { return nil },
+	}
+	done := make(chan error, 1)
+	go func() { done <- app.relayPostEvents(context.Background(), 0, 1, sink) }()
+
+	// Publish until the relay has subscribed, then close to end the stream.
+	deadline := time.After(time.Second)
+	for len(got) == 0 {
+		app.inbox.Publish(&store.Notification{ID: 2, UserID: 2})
+		app.inbox.Publish(&store.Notification{ID: 1, UserID: 1})
+		select {
+		case <-deadline:
+			t.Fatal("Expected the notification to be forwarded")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	app.broker.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected stream to end cleanly, got %v", err)
+	}
+	close(got)
+	for n := range got {
+		if n.UserID != 1 {
+			t.Errorf("Expected only user 1's notifications, got one for user %d", n.UserID)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"devops/internal/store"
 	"github.com/labstack/echo/v4"
 )
 
@@ -8,3 +9,8 @@ func (app *application) getUserFromContext(c echo.Context) string {
 	userEmail, _ := c.Request().Context().Value(userCtx).(string)
 	return userEmail
 }
+
+func (app *application) getAccountFromContext(c echo.Context) *store.User {
+	account, _ := c.Request().Context().Value(accountCtx).(*store.User)
+	return account
+}
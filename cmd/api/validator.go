@@ -2,6 +2,8 @@ package main
 
 import (
 	"github.com/go-playground/validator/v10"
+	"reflect"
+	"strings"
 )
 
 type ValidatorDirective struct {
@@ -12,4 +14,15 @@ var Validate *validator.Validate
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+	// Report fields by their JSON name so errors match the request body.
+	Validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
 }
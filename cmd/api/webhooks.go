@@ -0,0 +1,363 @@
+package main
+
+import (
+	"devops/internal/store"
+	"devops/internal/webhooks"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+// CreateWebhookPayload subscribes URL to Events, or to every event when
+// Events is empty.
+type CreateWebhookPayload struct {
+	URL         string   `json:"url" validate:"required,http_url,max=2000"`
+	Events      []string `json:"events" validate:"dive,oneof=post.created post.edited post.deleted post.hidden post.unhidden"`
+	Description string   `json:"description" validate:"max=255"`
+}
+
+// UpdateWebhookPayload changes only the fields that are present.
+// RotateSecret replaces the signing secret and returns the new one.
+type UpdateWebhookPayload struct {
+	URL          *string   `json:"url" validate:"omitempty,http_url,max=2000"`
+	Events       *[]string `json:"events" validate:"omitempty,dive,oneof=post.created post.edited post.deleted post.hidden post.unhidden"`
+	Description  *string   `json:"description" validate:"omitempty,max=255"`
+	Active       *bool     `json:"active"`
+	RotateSecret bool      `json:"rotate_secret"`
+}
+
+type WebhookDeliveryPage struct {
+	Deliveries []*store.WebhookDelivery `json:"deliveries"`
+	NextCursor int64                    `json:"next_cursor,omitempty"`
+}
+
+// @Summary List webhook subscriptions
+// @Description List every webhook subscription. Secrets are not included.
+// @Tags admin
+// @Produce json
+// @Success 200 {array} store.WebhookSubscription
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 403 {object} Problem "Insufficient role"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks [get]
+func (app *application) listWebhooks(c echo.Context) error {
+	subs, err := app.store.Webhooks.List(c.Request().Context())
+	if err != nil {
+		return internalError("Failed to retrieve webhooks", err)
+	}
+	return c.JSON(http.StatusOK, subs)
+}
+
+// @Summary Create a webhook subscription
+// @Description Subscribe a URL to post events. The response carries the signing secret, which is not shown again.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param payload body CreateWebhookPayload true "Subscription"
+// @Success 201 {object} store.WebhookSubscription
+// @Failure 400 {object} Problem "Invalid request format"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks [post]
+func (app *application) createWebhook(c echo.Context) error {
+	var req CreateWebhookPayload
+	if err := c.Bind(&req); err != nil {
+		return badRequest("Invalid request format")
+	}
+	if err := Validate.Struct(req); err != nil {
+		return validationFailed(err)
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return internalError("Failed to generate secret", err)
+	}
+	sub := &store.WebhookSubscription{
+		URL:         req.URL,
+		Secret:      secret,
+		Events:      req.Events,
+		Description: req.Description,
+		Active:      true,
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	if err := app.store.Webhooks.Create(c.Request().Context(), sub); err != nil {
+		return internalError("Failed to create webhook", err)
+	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditWebhookCreate,
+		TargetType: store.TargetWebhook,
+		TargetID:   strconv.FormatInt(sub.ID, 10),
+		Diff:       app.auditDiff(nil, withoutSecret(sub)),
+	})
+	return c.JSON(http.StatusCreated, sub)
+}
+
+// @Summary Get a webhook subscription
+// @Tags admin
+// @Produce json
+// @Param id path int true "Subscription id"
+// @Success 200 {object} store.WebhookSubscription
+// @Failure 400 {object} Problem "Invalid webhook ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Webhook not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks/{id} [get]
+func (app *application) getWebhook(c echo.Context) error {
+	sub, err := app.loadWebhook(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, sub)
+}
+
+// @Summary Update a webhook subscription
+// @Description Change the URL, events, description or active flag, or rotate the signing secret
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path int true "Subscription id"
+// @Param payload body UpdateWebhookPayload true "Changes"
+// @Success 200 {object} store.WebhookSubscription
+// @Failure 400 {object} Problem "Invalid request format"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Webhook not found"
+// @Failure 422 {object} Problem "Validation error"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks/{id} [patch]
+func (app *application) updateWebhook(c echo.Context) error {
+	sub, err := app.loadWebhook(c)
+	if err != nil {
+		return err
+	}
+	before := *sub
+
+	var req UpdateWebhookPayload
+	if err := c.Bind(&req); err != nil {
+		return badRequest("Invalid request format")
+	}
+	if err := Validate.Struct(req); err != nil {
+		return validationFailed(err)
+	}
+
+	if req.URL != nil {
+		sub.URL = *req.URL
+	}
+	if req.Events != nil {
+		sub.Events = *req.Events
+		if sub.Events == nil {
+			sub.Events = []string{}
+		}
+	}
+	if req.Description != nil {
+		sub.Description = *req.Description
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+	if req.RotateSecret {
+		if sub.Secret, err = webhooks.NewSecret(); err != nil {
+			return internalError("Failed to generate secret", err)
+		}
+	}
+
+	if err := app.store.Webhooks.Update(c.Request().Context(), sub); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return notFound("Webhook not found")
+		default:
+			return internalError("Failed to update webhook", err)
+		}
+	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditWebhookUpdate,
+		TargetType: store.TargetWebhook,
+		TargetID:   strconv.FormatInt(sub.ID, 10),
+		Diff:       app.auditDiff(&before, withoutSecret(sub)),
+	})
+	return c.JSON(http.StatusOK, sub)
+}
+
+// @Summary Delete a webhook subscription
+// @Description Delete a subscription together with its pending deliveries and delivery log
+// @Tags admin
+// @Produce json
+// @Param id path int true "Subscription id"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid webhook ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Webhook not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks/{id} [delete]
+func (app *application) deleteWebhook(c echo.Context) error {
+	sub, err := app.loadWebhook(c)
+	if err != nil {
+		return err
+	}
+	if err := app.store.Webhooks.Delete(c.Request().Context(), sub.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return notFound("Webhook not found")
+		default:
+			return internalError("Failed to delete webhook", err)
+		}
+	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditWebhookDelete,
+		TargetType: store.TargetWebhook,
+		TargetID:   strconv.FormatInt(sub.ID, 10),
+		Diff:       app.auditDiff(sub, nil),
+	})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Summary List webhook deliveries
+// @Description List the deliveries of a subscription, newest first
+// @Tags admin
+// @Produce json
+// @Param id path int true "Subscription id"
+// @Param status query string false "pending, succeeded or dead"
+// @Param cursor query int false "Return deliveries older than this id"
+// @Param limit query int false "Page size (max 200)"
+// @Success 200 {object} WebhookDeliveryPage
+// @Failure 400 {object} Problem "Invalid filter"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Webhook not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks/{id}/deliveries [get]
+func (app *application) listWebhookDeliveries(c echo.Context) error {
+	sub, err := app.loadWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	filter := store.DeliveryFilter{Status: c.QueryParam("status")}
+	switch filter.Status {
+	case "", store.DeliveryPending, store.DeliverySucceeded, store.DeliveryDead:
+	default:
+		return badRequest("Invalid filter")
+	}
+	if v := c.QueryParam("cursor"); v != "" {
+		if filter.Cursor, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return badRequest("Invalid filter")
+		}
+	}
+	if v := c.QueryParam("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return badRequest("Invalid filter")
+		}
+	}
+	if filter.Limit <= 0 || filter.Limit > maxDeliveryLimit {
+		filter.Limit = defaultDeliveryLimit
+	}
+
+	deliveries, err := app.store.Webhooks.ListDeliveries(c.Request().Context(), sub.ID, filter)
+	if err != nil {
+		return internalError("Failed to retrieve deliveries", err)
+	}
+	page := WebhookDeliveryPage{Deliveries: deliveries}
+	if len(deliveries) == filter.Limit {
+		page.NextCursor = deliveries[len(deliveries)-1].ID
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// @Summary List delivery attempts
+// @Description The delivery log of a single delivery, oldest attempt first
+// @Tags admin
+// @Produce json
+// @Param id path int true "Subscription id"
+// @Param delivery_id path int true "Delivery id"
+// @Success 200 {array} store.WebhookAttempt
+// @Failure 400 {object} Problem "Invalid delivery ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Webhook not found"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks/{id}/deliveries/{delivery_id}/attempts [get]
+func (app *application) listWebhookAttempts(c echo.Context) error {
+	sub, err := app.loadWebhook(c)
+	if err != nil {
+		return err
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		return badRequest("Invalid delivery ID")
+	}
+
+	attempts, err := app.store.Webhooks.ListAttempts(c.Request().Context(), sub.ID, deliveryID)
+	if err != nil {
+		return internalError("Failed to retrieve delivery attempts", err)
+	}
+	return c.JSON(http.StatusOK, attempts)
+}
+
+// @Summary Redeliver a webhook
+// @Description Queue a finished delivery, typically a dead-lettered one, to be sent again with a fresh retry budget
+// @Tags admin
+// @Produce json
+// @Param id path int true "Subscription id"
+// @Param delivery_id path int true "Delivery id"
+// @Success 202 "Accepted"
+// @Failure 400 {object} Problem "Invalid delivery ID"
+// @Failure 401 {object} Problem "Sign-in required"
+// @Failure 404 {object} Problem "Delivery not found or already pending"
+// @Failure 500 {object} Problem "Internal server error"
+// @Router /admin/webhooks/{id}/deliveries/{delivery_id}/redeliver [post]
+func (app *application) redeliverWebhook(c echo.Context) error {
+	sub, err := app.loadWebhook(c)
+	if err != nil {
+		return err
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		return badRequest("Invalid delivery ID")
+	}
+
+	if err := app.store.Webhooks.Redeliver(c.Request().Context(), sub.ID, deliveryID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return notFound("Delivery not found or already pending")
+		default:
+			return internalError("Failed to queue delivery", err)
+		}
+	}
+	app.audit(c, &store.AuditEvent{
+		Action:     store.AuditWebhookRedeliver,
+		TargetType: store.TargetWebhook,
+		TargetID:   strconv.FormatInt(sub.ID, 10),
+		Diff:       app.auditDiff(nil, map[string]int64{"delivery_id": deliveryID}),
+	})
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (app *application) loadWebhook(c echo.Context) (*store.WebhookSubscription, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, badRequest("Invalid webhook ID")
+	}
+	sub, err := app.store.Webhooks.GetByID(c.Request().Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return nil, notFound("Webhook not found")
+		default:
+			return nil, internalError("Failed to retrieve webhook", err)
+		}
+	}
+	return sub, nil
+}
+
+// withoutSecret keeps signing secrets out of the audit log.
+func withoutSecret(sub *store.WebhookSubscription) *store.WebhookSubscription {
+	clean := *sub
+	clean.Secret = ""
+	return &clean
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"devops/internal/store"
+	"testing"
+)
+
+func TestWebhookPayloadValidation(t *testing.T) {
+	url := "ftp://example.com/hook"
+	events := []string{store.TopicPostCreated, "post.liked"}
+	tests := []struct {
+		name    string
+		payload any
+		valid   bool
+	}{
+		{"every event", CreateWebhookPayload{URL: "https://example.com/hook"}, true},
+		{"known events", CreateWebhookPayload{URL: "http://svc.internal/hook", Events: store.Topics}, true},
+		{"missing url", CreateWebhookPayload{}, false},
+		{"unknown event", CreateWebhookPayload{URL: "https://example.com/hook", Events: events}, false},
+		{"empty update", UpdateWebhookPayload{}, true},
+		{"non-http url", UpdateWebhookPayload{URL: &url}, false},
+		{"unknown event in update", UpdateWebhookPayload{Events: &events}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate.Struct(tt.payload)
+			if tt.valid && err != nil {
+				t.Errorf("Expected payload to be valid, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected payload to be rejected")
+			}
+		})
+	}
+}
@@ -1,83 +1,390 @@
 package auth
 
 import (
-	"devops/internal/env"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
 	"github.com/markbates/goth/providers/google"
+	"golang.org/x/oauth2"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
 const (
-	maxAge = 60 * 60 * 24 * 7 // 7 days
-	isProd = false
+	maxAge     = 60 * 60 * 24 * 7 // 7 days
+	flowMaxAge = 60 * 10          // 10 minutes to complete the provider round trip
+
+	sessionName     = "_devops_session"
+	flowSessionName = "_devops_oauth_flow"
+
+	minHashKeyLength = 32
+)
+
+var (
+	callbackURL     = "http://localhost:3000/auth/google/callback"
+	defaultRedirect = "http://localhost:5173/"
+	redirectOrigins = []string{"http://localhost:5173", "http://localhost:3000"}
+)
+
+var (
+	ErrStateMismatch   = errors.New("oauth state mismatch")
+	ErrInvalidRedirect = errors.New("redirect target is not allowed")
+	ErrNoSession       = errors.New("no active session")
 )
 
+// Config holds the OAuth client and session cookie settings.
+type Config struct {
+	Env          string
+	ClientID     string
+	ClientSecret string
+	CallbackURL  string
+	// SessionKeys are "hashKey" or "hashKey:blockKey" entries, newest first.
+	// New cookies are signed with the first entry; the rest are only used to
+	// decode existing cookies so a key can be retired without logging
+	// everyone out.
+	SessionKeys     []string
+	CookieDomain    string
+	CookieSameSite  string
+	DefaultRedirect string
+	RedirectOrigins []string
+}
+
+func (cfg Config) IsProd() bool {
+	return cfg.Env == "production"
+}
+
+// Validate reports configuration that must never reach production: missing
+// OAuth credentials, missing or short session keys and insecure cookies.
+func (cfg Config) Validate() error {
+	var errs []error
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		errs = append(errs, errors.New("CLIENT_ID and CLIENT_SECRET are required"))
+	}
+	if len(cfg.SessionKeys) == 0 {
+		errs = append(errs, errors.New("at least one session key is required"))
+	}
+	for i, raw := range cfg.SessionKeys {
+		hashKey, blockKey, _ := strings.Cut(raw, ":")
+		if len(hashKey) < minHashKeyLength {
+			errs = append(errs, fmt.Errorf("session key %d: hash key must be at least %d bytes", i, minHashKeyLength))
+		}
+		if blockKey != "" && len(blockKey) != 16 && len(blockKey) != 24 && len(blockKey) != 32 {
+			errs = append(errs, fmt.Errorf("session key %d: block key must be 16, 24 or 32 bytes", i))
+		}
+	}
+	if _, err := parseSameSite(cfg.CookieSameSite); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.IsProd() && !strings.HasPrefix(cfg.CallbackURL, "https://") {
+		errs = append(errs, errors.New("callback URL must use https in production"))
+	}
+	return errors.Join(errs...)
+}
+
+// pkceProvider describes a provider whose token endpoint accepts a PKCE code
+// verifier. goth does not forward the verifier itself, so the code exchange
+// is done here and the resulting token is copied into the provider session.
+type pkceProvider struct {
+	config     *oauth2.Config
+	applyToken func(goth.Session, *oauth2.Token)
+}
+
 var (
-	clientID     = env.GetString("CLIENT_ID", "")
-	clientSecret = env.GetString("CLIENT_SECRET", "")
-	key          = env.GetString("SECRET_KEY", "")
+	sessionStore  *sessions.CookieStore
+	pkceProviders = map[string]pkceProvider{}
 )
 
-func NewAuth() {
+// NewAuth registers the OAuth providers and the session cookie store. In
+// production an invalid configuration is fatal; elsewhere missing session
+// keys are replaced by a random key that does not survive restarts.
+func NewAuth(cfg Config) error {
+	if err := cfg.Validate(); err != nil && cfg.IsProd() {
+		return fmt.Errorf("invalid auth configuration: %w", err)
+	}
+	sameSite, err := parseSameSite(cfg.CookieSameSite)
+	if err != nil {
+		return err
+	}
+
+	if cfg.CallbackURL != "" {
+		callbackURL = cfg.CallbackURL
+	}
+	if cfg.DefaultRedirect != "" {
+		defaultRedirect = cfg.DefaultRedirect
+	}
+	if len(cfg.RedirectOrigins) > 0 {
+		redirectOrigins = cfg.RedirectOrigins
+	}
+
 	goth.UseProviders(
-		google.New(clientID, clientSecret, "http://localhost:3000/auth/google/callback"),
+		google.New(cfg.ClientID, cfg.ClientSecret, callbackURL),
 	)
 
-	store := sessions.NewCookieStore([]byte(key))
+	pkceProviders["google"] = pkceProvider{
+		config: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  callbackURL,
+			Endpoint:     google.Endpoint,
+		},
+		applyToken: func(s goth.Session, token *oauth2.Token) {
+			sess := s.(*google.Session)
+			sess.AccessToken = token.AccessToken
+			sess.RefreshToken = token.RefreshToken
+			sess.ExpiresAt = token.Expiry
+			if idToken, ok := token.Extra("id_token").(string); ok {
+				sess.IDToken = idToken
+			}
+		},
+	}
+
+	keyPairs, err := sessionKeyPairs(cfg.SessionKeys)
+	if err != nil {
+		return err
+	}
+	store := sessions.NewCookieStore(keyPairs...)
 	store.MaxAge(maxAge)
 
-	store.Options.Domain = ""
+	store.Options.Domain = cfg.CookieDomain
 	store.Options.Path = "/"
 	store.Options.HttpOnly = true
-	store.Options.Secure = isProd
-	store.Options.SameSite = http.SameSiteLaxMode
+	store.Options.Secure = cfg.IsProd() || sameSite == http.SameSiteNoneMode
+	store.Options.SameSite = sameSite
 
+	sessionStore = store
 	gothic.Store = store
+	return nil
+}
 
+// sessionKeyPairs converts the configured keys into the alternating
+// hash/block key list expected by securecookie.
+func sessionKeyPairs(keys []string) ([][]byte, error) {
+	if len(keys) == 0 {
+		random := make([]byte, minHashKeyLength)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		return [][]byte{random, nil}, nil
+	}
+	pairs := make([][]byte, 0, len(keys)*2)
+	for _, raw := range keys {
+		hashKey, blockKey, _ := strings.Cut(raw, ":")
+		var block []byte
+		if blockKey != "" {
+			block = []byte(blockKey)
+		}
+		pairs = append(pairs, []byte(hashKey), block)
+	}
+	return pairs, nil
 }
 
-func BeginAuth(w http.ResponseWriter, r *http.Request, provider string) {
-	q := r.URL.Query()
-	q.Set("provider", strings.ToLower(provider))
-	r.URL.RawQuery = q.Encode()
-	gothic.BeginAuthHandler(w, r)
+func parseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown cookie SameSite mode %q", mode)
+	}
 }
 
-func CompleteAuth(w http.ResponseWriter, r *http.Request, provider string) (goth.User, error) {
-	q := r.URL.Query()
-	q.Set("provider", strings.ToLower(provider))
-	r.URL.RawQuery = q.Encode()
-	return gothic.CompleteUserAuth(w, r)
+// BeginAuth starts the OAuth flow for provider and returns the provider URL
+// the browser should be sent to. The state, PKCE verifier and the validated
+// post-login redirect are kept in a short-lived flow cookie.
+func BeginAuth(w http.ResponseWriter, r *http.Request, providerName, redirectTo string) (string, error) {
+	providerName = strings.ToLower(providerName)
+	provider, err := goth.GetProvider(providerName)
+	if err != nil {
+		return "", err
+	}
+	redirectTo, err = ValidateRedirect(redirectTo)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	sess, err := provider.BeginAuth(state)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := sess.GetAuthURL()
+	if err != nil {
+		return "", err
+	}
+
+	flow, _ := sessionStore.New(r, flowSessionName)
+	flow.Options = flowOptions()
+	flow.Values["provider"] = providerName
+	flow.Values["state"] = state
+	flow.Values["redirect_to"] = redirectTo
+	flow.Values["session"] = sess.Marshal()
+
+	if _, ok := pkceProviders[providerName]; ok {
+		verifier := oauth2.GenerateVerifier()
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("code_challenge", oauth2.S256ChallengeFromVerifier(verifier))
+		q.Set("code_challenge_method", "S256")
+		u.RawQuery = q.Encode()
+		authURL = u.String()
+		flow.Values["verifier"] = verifier
+	}
+
+	if err := flow.Save(r, w); err != nil {
+		return "", err
+	}
+	return authURL, nil
+}
+
+// CompleteAuth validates the callback against the flow cookie, exchanges the
+// authorization code and establishes the user session. It returns the
+// redirect target stored by BeginAuth, falling back to the default SPA URL.
+func CompleteAuth(w http.ResponseWriter, r *http.Request, providerName string) (goth.User, string, error) {
+	redirectTo := defaultRedirect
+	providerName = strings.ToLower(providerName)
+
+	flow, err := sessionStore.Get(r, flowSessionName)
+	if err != nil {
+		return goth.User{}, redirectTo, err
+	}
+	// The flow is single use: clear it whatever the outcome.
+	defer func() {
+		flow.Options = flowOptions()
+		flow.Options.MaxAge = -1
+		_ = flow.Save(r, w)
+	}()
+
+	if v, ok := flow.Values["redirect_to"].(string); ok && v != "" {
+		redirectTo = v
+	}
+	if flowProvider, _ := flow.Values["provider"].(string); flowProvider != providerName {
+		return goth.User{}, redirectTo, ErrStateMismatch
+	}
+	expected, _ := flow.Values["state"].(string)
+	got := r.URL.Query().Get("state")
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(got)) != 1 {
+		return goth.User{}, redirectTo, ErrStateMismatch
+	}
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		return goth.User{}, redirectTo, errors.New("provider returned error: " + errCode)
+	}
+
+	provider, err := goth.GetProvider(providerName)
+	if err != nil {
+		return goth.User{}, redirectTo, err
+	}
+	marshalled, _ := flow.Values["session"].(string)
+	sess, err := provider.UnmarshalSession(marshalled)
+	if err != nil {
+		return goth.User{}, redirectTo, err
+	}
+
+	if pkce, ok := pkceProviders[providerName]; ok {
+		verifier, _ := flow.Values["verifier"].(string)
+		ctx := context.WithValue(r.Context(), oauth2.HTTPClient, goth.HTTPClientWithFallBack(nil))
+		token, err := pkce.config.Exchange(ctx, r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+		if err != nil {
+			return goth.User{}, redirectTo, err
+		}
+		pkce.applyToken(sess, token)
+	} else if _, err := sess.Authorize(provider, r.URL.Query()); err != nil {
+		return goth.User{}, redirectTo, err
+	}
+
+	user, err := provider.FetchUser(sess)
+	if err != nil {
+		return goth.User{}, redirectTo, err
+	}
+
+	session, _ := sessionStore.New(r, sessionName)
+	session.Values["provider"] = providerName
+	session.Values["session"] = sess.Marshal()
+	session.Values["subject"] = providerName + ":" + user.UserID
+	if err := session.Save(r, w); err != nil {
+		return goth.User{}, redirectTo, err
+	}
+	return user, redirectTo, nil
+}
+
+// ValidateRedirect resolves an empty target to the default SPA URL and
+// rejects anything whose origin is not in the configured allowlist.
+func ValidateRedirect(raw string) (string, error) {
+	if raw == "" {
+		return defaultRedirect, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.User != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ErrInvalidRedirect
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, allowed := range redirectOrigins {
+		if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return u.String(), nil
+		}
+	}
+	return "", ErrInvalidRedirect
 }
 
 func Logout(w http.ResponseWriter, r *http.Request) error {
+	session, _ := sessionStore.Get(r, sessionName)
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
 	return gothic.Logout(w, r)
 }
 
-func GetUserFromSession(r *http.Request) (*goth.User, error) {
-	// This will look up the provider name stored in the session
-	provider, err := gothic.GetFromSession("provider", r)
+// SessionSubject returns a stable identifier for the signed-in user, or ""
+// for anonymous requests. Unlike GetUserFromSession it only decodes the
+// session cookie and never calls the provider, so it is cheap enough to run
+// on every request.
+func SessionSubject(r *http.Request) string {
+	if sessionStore == nil {
+		return ""
+	}
+	session, err := sessionStore.Get(r, sessionName)
 	if err != nil {
-		return nil, err // no provider in session → not logged in
+		return ""
 	}
+	subject, _ := session.Values["subject"].(string)
+	return subject
+}
 
-	// Get the provider instance
-	p, err := goth.GetProvider(provider)
+func GetUserFromSession(r *http.Request) (*goth.User, error) {
+	session, err := sessionStore.Get(r, sessionName)
 	if err != nil {
 		return nil, err
 	}
+	provider, _ := session.Values["provider"].(string)
+	marshalled, _ := session.Values["session"].(string)
+	if provider == "" || marshalled == "" {
+		return nil, ErrNoSession // not logged in
+	}
 
-	// Get the existing session
-	sess, err := gothic.GetFromSession(provider, r)
+	// Get the provider instance
+	p, err := goth.GetProvider(provider)
 	if err != nil {
 		return nil, err
 	}
 
 	// Unmarshal into provider's session type
-	s, err := p.UnmarshalSession(sess)
+	s, err := p.UnmarshalSession(marshalled)
 	if err != nil {
 		return nil, err
 	}
@@ -90,3 +397,17 @@ func GetUserFromSession(r *http.Request) (*goth.User, error) {
 
 	return &user, nil
 }
+
+func flowOptions() *sessions.Options {
+	opts := *sessionStore.Options
+	opts.MaxAge = flowMaxAge
+	return &opts
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"testing"
+)
+
+func TestValidateRedirect(t *testing.T) {
+	redirectOrigins = []string{"http://localhost:5173", "https://app.example.com"}
+
+	allowed := []string{
+		"http://localhost:5173/posts",
+		"https://app.example.com/",
+		"HTTPS://APP.EXAMPLE.COM/feed?tab=new",
+	}
+	for _, target := range allowed {
+		if _, err := ValidateRedirect(target); err != nil {
+			t.Errorf("Expected %q to be allowed, got %v", target, err)
+		}
+	}
+
+	rejected := []string{
+		"https://evil.example.com/",
+		"https://app.example.com.evil.com/",
+		"https://user@app.example.com/",
+		"javascript:alert(1)",
+		"//app.example.com/",
+		"/relative",
+	}
+	for _, target := range rejected {
+		if _, err := ValidateRedirect(target); err == nil {
+			t.Errorf("Expected %q to be rejected", target)
+		}
+	}
+
+	if got, err := ValidateRedirect(""); err != nil || got != defaultRedirect {
+		t.Errorf("Expected default redirect %q, got %q (%v)", defaultRedirect, got, err)
+	}
+}
+
+func TestNewAuthRejectsWeakProductionConfig(t *testing.T) {
+	cfg := Config{
+		Env:          "production",
+		ClientID:     "id",
+		ClientSecret: "secret",
+		CallbackURL:  "https://api.example.com/auth/google/callback",
+		SessionKeys:  []string{"too-short"},
+	}
+	if err := NewAuth(cfg); err == nil {
+		t.Errorf("Expected weak session key to be rejected in production")
+	}
+
+	cfg.SessionKeys = []string{"0123456789abcdef0123456789abcdef:0123456789abcdef", "fedcba9876543210fedcba9876543210"}
+	if err := NewAuth(cfg); err != nil {
+		t.Errorf("Expected rotated keys to be accepted, got %v", err)
+	}
+	if !sessionStore.Options.Secure {
+		t.Errorf("Expected secure cookies in production")
+	}
+
+	if err := NewAuth(Config{Env: "development"}); err != nil {
+		t.Errorf("Expected development to fall back to an ephemeral key, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+// Package cache provides byte-oriented key/value caches with expiry: an
+// in-process LRU and a Redis client for caches shared between replicas.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache stores opaque values. A miss is reported with ok == false and a nil
+// error; errors mean the backend itself failed.
+type Cache interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
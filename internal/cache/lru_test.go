@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := c.Get(ctx, key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestLRUExpiryAndDelete(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	c := NewLRU(10)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", []byte("1"), time.Second)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	c.Set(ctx, "c", []byte("3"), time.Minute)
+
+	now = now.Add(2 * time.Second)
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Error("Expected a to have expired")
+	}
+
+	c.Delete(ctx, "b", "missing")
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Error("Expected b to be deleted")
+	}
+	if v, ok, _ := c.Get(ctx, "c"); !ok || string(v) != "3" {
+		t.Errorf("Expected c to be kept, got %q", v)
+	}
+}
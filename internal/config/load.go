@@ -0,0 +1,295 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field is a single configurable leaf of Config.
+type field struct {
+	path   string
+	envs   []string
+	def    string
+	hasDef bool
+	secret bool
+	value  reflect.Value
+}
+
+func (f field) flagName() string {
+	return strings.ReplaceAll(f.path, "_", "-")
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment and the flags in args, in that order of precedence. Config
+// flags are registered on fs so callers can add their own flags first.
+// The config file is taken from -config or CONFIG_FILE.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := &Config{}
+	fields := collect(reflect.ValueOf(cfg).Elem(), "")
+
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		usage := f.path
+		if len(f.envs) > 0 {
+			usage += " (env " + strings.Join(f.envs, ", ") + ")"
+		}
+		flagValues[f.flagName()] = fs.String(f.flagName(), f.def, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		if f.hasDef {
+			if err := set(f.value, f.def); err != nil {
+				return nil, fmt.Errorf("default for %s: %w", f.path, err)
+			}
+		}
+	}
+
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		byPath := make(map[string]field, len(fields))
+		for _, f := range fields {
+			byPath[f.path] = f
+		}
+		for path, raw := range values {
+			f, ok := byPath[path]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown config key %q", *configFile, path)
+			}
+			if err := set(f.value, raw); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", *configFile, path, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		for _, name := range f.envs {
+			if raw, ok := os.LookupEnv(name); ok {
+				if err := set(f.value, raw); err != nil {
+					return nil, fmt.Errorf("env %s: %w", name, err)
+				}
+				break
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(fl *flag.Flag) {
+		raw, ok := flagValues[fl.Name]
+		if !ok || flagErr != nil {
+			return
+		}
+		for _, f := range fields {
+			if f.flagName() == fl.Name {
+				if err := set(f.value, *raw); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", fl.Name, err)
+				}
+				return
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := validator.New(validator.WithRequiredStructEnabled()).Struct(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Print writes the effective configuration as YAML. With redact set, fields
+// tagged secret are masked.
+func (cfg *Config) Print(w io.Writer, redact bool) error {
+	out := map[string]any{}
+	for _, f := range collect(reflect.ValueOf(cfg).Elem(), "") {
+		var v any = f.value.Interface()
+		switch {
+		case redact && f.secret && !f.value.IsZero():
+			v = redacted
+		case f.value.Type() == durationType:
+			v = f.value.Interface().(time.Duration).String()
+		}
+
+		node := out
+		parts := strings.Split(f.path, ".")
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := node[part].(map[string]any)
+			if !ok {
+				next = map[string]any{}
+				node[part] = next
+			}
+			node = next
+		}
+		node[parts[len(parts)-1]] = v
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func collect(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := sf.Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			fields = append(fields, collect(v.Field(i), path)...)
+			continue
+		}
+
+		f := field{path: path, secret: sf.Tag.Get("secret") == "true", value: v.Field(i)}
+		if env := sf.Tag.Get("env"); env != "" {
+			f.envs = strings.Split(env, ",")
+		}
+		f.def, f.hasDef = sf.Tag.Lookup("default")
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// set parses raw into v according to v's type.
+func set(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				items = append(items, s)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// readFile decodes a YAML or TOML file into dotted-path keys with values in
+// the same string form accepted from the environment.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file format", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	if err := flatten(doc, "", values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flatten(node map[string]any, prefix string, out map[string]string) error {
+	keys := make([]string, 0, len(node))
+	for k := range node {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		switch v := node[k].(type) {
+		case map[string]any:
+			if err := flatten(v, path, out); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[path] = strings.Join(items, ",")
+		case nil:
+			return errors.New(path + ": empty value")
+		default:
+			out[path] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+db:
+  max_open_conns: 20
+  max_idle_conns: 5
+  max_idle_time: 1m
+http:
+  read_timeout: 3s
+auth:
+  redirect_origins: ["https://a.example.com", "https://b.example.com"]
+`)
+	t.Setenv("DB_MAX_OPEN_CONNS", "30")
+	t.Setenv("HTTP_READ_TIMEOUT", "4s")
+
+	cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path, "-db.max-open-conns", "40"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.DB.MaxOpenConns != 40 {
+		t.Errorf("Expected flag to win with 40, got %v", cfg.DB.MaxOpenConns)
+	}
+	if cfg.HTTP.ReadTimeout != 4*time.Second {
+		t.Errorf("Expected env to win with 4s, got %v", cfg.HTTP.ReadTimeout)
+	}
+	if cfg.DB.MaxIdleConns != 5 || cfg.DB.MaxIdleTime != time.Minute {
+		t.Errorf("Expected file values, got %v and %v", cfg.DB.MaxIdleConns, cfg.DB.MaxIdleTime)
+	}
+	if len(cfg.Auth.RedirectOrigins) != 2 {
+		t.Errorf("Expected 2 redirect origins, got %v", cfg.Auth.RedirectOrigins)
+	}
+	if cfg.Addr != ":8080" {
+		t.Errorf("Expected default addr, got %v", cfg.Addr)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+env = "staging"
+
+[tracing]
+exporter = "stdout"
+sample_ratio = 0.5
+`)
+	cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Env != "staging" || cfg.Tracing.Exporter != "stdout" || cfg.Tracing.SampleRatio != 0.5 {
+		t.Errorf("Expected TOML values, got %+v", cfg)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	unknown := writeFile(t, "config.yaml", "db:\n  max_conns: 3\n")
+	if _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", unknown}); err == nil {
+		t.Errorf("Expected unknown key to be rejected")
+	}
+
+	if _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-env", "prod"}); err == nil {
+		t.Errorf("Expected invalid env to be rejected")
+	}
+
+	if _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-db.max-idle-conns", "20"}); err == nil {
+		t.Errorf("Expected idle connections above open connections to be rejected")
+	}
+}
@@ -0,0 +1,131 @@
+// Package content extracts structure from post text: @mentions, resolved
+// against the user directory, and #hashtags.
+package content
+
+import (
+	"context"
+	"devops/internal/store"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// A sigil only counts at the start of a word, so e-mail addresses, a#b
+	// and HTML entities such as &#39; are not picked up.
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{M}\p{N}_@.])@([\p{L}\p{M}\p{N}_]+)`)
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{M}\p{N}_&#])#([\p{L}\p{M}\p{N}_]+)`)
+)
+
+// Users looks up users by name; see store.UsersStore.GetUsersByUsernames.
+type Users interface {
+	GetUsersByUsernames(ctx context.Context, usernames []string) ([]*store.User, error)
+}
+
+// Parse returns the mentions and hashtags in text, in order of appearance.
+// Mentions are unresolved: they carry the name as written and no user id.
+func Parse(text string) store.Entities {
+	entities := store.Entities{Mentions: []store.Mention{}, Hashtags: []store.Hashtag{}}
+	offsets := newOffsets(text)
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		entities.Mentions = append(entities.Mentions, store.Mention{
+			Username: text[m[2]:m[3]],
+			Start:    offsets.utf16(m[2] - 1),
+			End:      offsets.utf16(m[3]),
+		})
+	}
+	offsets = newOffsets(text)
+	for _, m := range hashtagPattern.FindAllStringSubmatchIndex(text, -1) {
+		entities.Hashtags = append(entities.Hashtags, store.Hashtag{
+			Tag:   strings.ToLower(text[m[2]:m[3]]),
+			Start: offsets.utf16(m[2] - 1),
+			End:   offsets.utf16(m[3]),
+		})
+	}
+	return entities
+}
+
+// Resolve looks up every mention in e and fills in the user. Mentions of
+// unknown names are dropped, as are names shared by several users, since
+// usernames are not unique and a guess could notify the wrong person.
+func Resolve(ctx context.Context, users Users, e *store.Entities) error {
+	if len(e.Mentions) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(e.Mentions))
+	for _, m := range e.Mentions {
+		names = append(names, m.Username)
+	}
+	found, err := users.GetUsersByUsernames(ctx, names)
+	if err != nil {
+		return err
+	}
+
+	byName := map[string][]*store.User{}
+	for _, u := range found {
+		key := strings.ToLower(u.Username)
+		byName[key] = append(byName[key], u)
+	}
+	resolved := e.Mentions[:0]
+	for _, m := range e.Mentions {
+		matches := byName[strings.ToLower(m.Username)]
+		if len(matches) != 1 {
+			continue
+		}
+		m.UserID, m.Username = matches[0].ID, matches[0].Username
+		resolved = append(resolved, m)
+	}
+	e.Mentions = resolved
+	return nil
+}
+
+// Tags returns the distinct hashtags of e in order of first use.
+func Tags(e store.Entities) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, h := range e.Hashtags {
+		if !seen[h.Tag] {
+			seen[h.Tag] = true
+			tags = append(tags, h.Tag)
+		}
+	}
+	return tags
+}
+
+// MentionedUsers returns the distinct ids of the users mentioned in e.
+func MentionedUsers(e store.Entities) []int64 {
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, m := range e.Mentions {
+		if !seen[m.UserID] {
+			seen[m.UserID] = true
+			ids = append(ids, m.UserID)
+		}
+	}
+	return ids
+}
+
+// offsets converts increasing byte offsets into a string to UTF-16 offsets
+// without rescanning from the start each time.
+type offsets struct {
+	text  string
+	byte  int
+	units int
+}
+
+func newOffsets(text string) *offsets {
+	return &offsets{text: text}
+}
+
+func (o *offsets) utf16(byteOffset int) int {
+	for o.byte < byteOffset {
+		r, size := utf8.DecodeRuneInString(o.text[o.byte:])
+		o.byte += size
+		if r >= 0x10000 {
+			o.units += 2
+		} else {
+			o.units++
+		}
+	}
+	return o.units
+}
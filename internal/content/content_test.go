@@ -0,0 +1,68 @@
+package content
+
+import (
+	"context"
+	"devops/internal/store"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	got := Parse("Hi @alice 👋 see #Go and #go! mail bob@example.com, a#b, &#39; @Zoë")
+
+	wantMentions := []store.Mention{
+		{Username: "alice", Start: 3, End: 9},
+		{Username: "Zoë", Start: 63, End: 67},
+	}
+	if !reflect.DeepEqual(got.Mentions, wantMentions) {
+		t.Errorf("Expected mentions %+v, got %+v", wantMentions, got.Mentions)
+	}
+	// The emoji is two UTF-16 units, so later offsets shift by one more than
+	// its rune count.
+	wantHashtags := []store.Hashtag{
+		{Tag: "go", Start: 17, End: 20},
+		{Tag: "go", Start: 25, End: 28},
+	}
+	if !reflect.DeepEqual(got.Hashtags, wantHashtags) {
+		t.Errorf("Expected hashtags %+v, got %+v", wantHashtags, got.Hashtags)
+	}
+	if tags := Tags(got); !reflect.DeepEqual(tags, []string{"go"}) {
+		t.Errorf("Expected distinct tags [go], got %v", tags)
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	got := Parse("nothing here")
+	if got.Mentions == nil || got.Hashtags == nil {
+		t.Errorf("Expected empty, non-nil lists, got %#v", got)
+	}
+}
+
+type fakeUsers []*store.User
+
+func (f fakeUsers) GetUsersByUsernames(context.Context, []string) ([]*store.User, error) {
+	return f, nil
+}
+
+func TestResolve(t *testing.T) {
+	users := fakeUsers{
+		{ID: 1, Username: "Alice"},
+		{ID: 2, Username: "sam"},
+		{ID: 3, Username: "Sam"},
+	}
+	e := Parse("@alice @sam @nobody @ALICE")
+	if err := Resolve(context.Background(), users, &e); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []store.Mention{
+		{UserID: 1, Username: "Alice", Start: 0, End: 6},
+		{UserID: 1, Username: "Alice", Start: 20, End: 26},
+	}
+	if !reflect.DeepEqual(e.Mentions, want) {
+		t.Errorf("Expected %+v, got %+v", want, e.Mentions)
+	}
+	if ids := MentionedUsers(e); !reflect.DeepEqual(ids, []int64{1}) {
+		t.Errorf("Expected mentioned users [1], got %v", ids)
+	}
+}
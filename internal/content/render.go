@@ -0,0 +1,40 @@
+package content
+
+import (
+	"bytes"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"html"
+	"strings"
+)
+
+// Post body formats.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+var (
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	// sanitizer allows the usual user-content markup and nothing that can
+	// run script: no script or style elements, no event handler attributes
+	// and only http, https and mailto URLs. Goldmark already drops raw HTML;
+	// the sanitizer is what the guarantee rests on.
+	sanitizer = bluemonday.UGCPolicy().AddTargetBlankToFullyQualifiedLinks(true)
+)
+
+// Render returns text as sanitised HTML. Plain text is escaped, with line
+// breaks kept.
+func Render(format, text string) (string, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if format != FormatMarkdown {
+		return "<p>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>\n") + "</p>", nil
+	}
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(text), &buf); err != nil {
+		return "", err
+	}
+	return sanitizer.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,56 @@
+package content
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderPlain(t *testing.T) {
+	got, err := Render(FormatPlain, "a <b>\r\n**c**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<p>a &lt;b&gt;<br>\n**c**</p>"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	got, err := Render(FormatMarkdown, "# Title\n\n**bold** and [link](https://example.com)\n\n- item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<h1>Title</h1>",
+		"<strong>bold</strong>",
+		`<a href="https://example.com" rel="nofollow noopener" target="_blank">link</a>`,
+		"<li>item</li>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in %q", want, got)
+		}
+	}
+}
+
+func TestRenderMarkdownSanitises(t *testing.T) {
+	inputs := []string{
+		"<script>alert(1)</script>",
+		`<img src="x" onerror="alert(1)">`,
+		"[click](javascript:alert(1))",
+		`<a href="javascript:alert(1)">click</a>`,
+		"[click](JaVaScRiPt:alert(1))",
+		`![img](x" onerror="alert(1))`,
+	}
+	for _, in := range inputs {
+		got, err := Render(FormatMarkdown, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lower := strings.ToLower(got)
+		for _, bad := range []string{"<script", `onerror="`, "javascript:"} {
+			if strings.Contains(lower, bad) {
+				t.Errorf("Render(%q) = %q, which contains %q", in, got, bad)
+			}
+		}
+	}
+}
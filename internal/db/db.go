@@ -7,18 +7,14 @@ import (
 	"devops/internal/store"
 )
 
-func New(addr string, maxOpenConnections, maxIdleConnections int, maxIdleTime string) (*sql.DB, error) {
+func New(addr string, maxOpenConnections, maxIdleConnections int, maxIdleTime time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("postgres", addr)
 	if err != nil {
 		return nil, err
 	}
 	db.SetMaxOpenConns(maxOpenConnections)
 	db.SetMaxIdleConns(maxIdleConnections)
-	duration, err := time.ParseDuration(maxIdleTime)
-	if err != nil {
-		return nil, err
-	}
-	db.SetConnMaxLifetime(duration)
+	db.SetConnMaxIdleTime(maxIdleTime)
 	ctx, cancel := context.WithTimeout(context.Background(), store.QueryTimeOut)
 	defer cancel()
 	err = db.PingContext(ctx)
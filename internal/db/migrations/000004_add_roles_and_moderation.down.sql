@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS moderation_actions;
+ALTER TABLE posts DROP COLUMN hidden;
+ALTER TABLE users DROP COLUMN banned;
+ALTER TABLE users DROP COLUMN role;
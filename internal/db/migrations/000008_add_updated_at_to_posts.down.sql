@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS posts_set_updated_at ON posts;
+DROP FUNCTION IF EXISTS posts_set_updated_at();
+ALTER TABLE posts DROP COLUMN IF EXISTS updated_at;
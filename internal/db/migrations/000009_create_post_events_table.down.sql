@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS posts_publish_event ON posts;
+DROP FUNCTION IF EXISTS posts_publish_event();
+DROP TABLE IF EXISTS post_events;
@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS post_events (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(20) NOT NULL,
+    post_id INT NOT NULL,
+    post JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_events_created_at ON post_events (created_at);
+
+-- posts_publish_event records every change to a visible post and notifies
+-- listeners with the event id. Hiding a post is published as a deletion and
+-- unhiding as a creation, so subscribers only ever see the public feed.
+CREATE OR REPLACE FUNCTION posts_publish_event() RETURNS trigger AS $$
+DECLARE
+    event_type TEXT;
+    rec posts;
+    event_id BIGINT;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.hidden THEN RETURN NULL; END IF;
+        event_type := 'created';
+        rec := NEW;
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.hidden AND NEW.hidden THEN RETURN NULL; END IF;
+        IF NEW.hidden THEN
+            event_type := 'deleted';
+        ELSIF OLD.hidden THEN
+            event_type := 'created';
+        ELSE
+            event_type := 'edited';
+        END IF;
+        rec := NEW;
+    ELSE
+        IF OLD.hidden THEN RETURN NULL; END IF;
+        event_type := 'deleted';
+        rec := OLD;
+    END IF;
+
+    -- Serialise writers until commit so that event ids become visible in
+    -- order; readers resume with "id > last seen" and must not skip any.
+    PERFORM pg_advisory_xact_lock(hashtext('post_events'));
+
+    INSERT INTO post_events (type, post_id, post)
+    VALUES (
+        event_type,
+        rec.id,
+        CASE WHEN event_type = 'deleted' THEN NULL
+             ELSE (to_jsonb(rec) - 'image') || jsonb_build_object('photo_url', rec.image)
+        END
+    )
+    RETURNING id INTO event_id;
+
+    PERFORM pg_notify('post_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER posts_publish_event
+    AFTER INSERT OR UPDATE OR DELETE ON posts
+    FOR EACH ROW EXECUTE FUNCTION posts_publish_event();
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    state VARCHAR(20) NOT NULL DEFAULT 'queued',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    unique_key VARCHAR(255),
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs (run_at, id) WHERE state = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_running ON jobs (locked_until) WHERE state = 'running';
+CREATE INDEX IF NOT EXISTS idx_jobs_state ON jobs (state, id);
+
+-- A unique job can only be enqueued once while a previous copy is waiting
+-- or running.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique ON jobs (kind, unique_key)
+    WHERE unique_key IS NOT NULL AND state IN ('queued', 'running');
+
+CREATE TABLE IF NOT EXISTS job_schedules (
+    kind VARCHAR(100) PRIMARY KEY,
+    next_run_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS notifications_publish ON notifications;
+DROP FUNCTION IF EXISTS notifications_publish();
+DROP TABLE IF EXISTS notifications;
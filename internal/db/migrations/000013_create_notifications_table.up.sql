@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    data JSONB NOT NULL,
+    read_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_unread ON notifications (user_id) WHERE read_at IS NULL;
+
+-- notifications_publish pushes each new notification to the API replicas,
+-- which forward it to the recipient's open streams. The inbox itself is the
+-- source of truth, so a notification missed while disconnected is simply
+-- picked up on the next inbox read.
+CREATE OR REPLACE FUNCTION notifications_publish() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('notifications', json_build_object(
+        'id', NEW.id,
+        'user_id', NEW.user_id,
+        'kind', NEW.kind,
+        'data', NEW.data,
+        'created_at', NEW.created_at
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notifications_publish
+    AFTER INSERT ON notifications
+    FOR EACH ROW EXECUTE FUNCTION notifications_publish();
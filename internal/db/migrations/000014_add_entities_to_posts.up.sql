@@ -0,0 +1,3 @@
+-- Mentions and hashtags extracted from content when a post is written.
+-- Existing posts start empty and pick up their entities on the next edit.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS entities JSONB NOT NULL DEFAULT '{"mentions": [], "hashtags": []}';
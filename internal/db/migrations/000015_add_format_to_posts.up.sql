@@ -0,0 +1,17 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS format VARCHAR(20) NOT NULL DEFAULT 'plain';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS content_html TEXT NOT NULL DEFAULT '';
+
+-- Render existing posts the way the API renders plain text: escaped, with
+-- line breaks kept. Triggers are off so the backfill neither bumps
+-- updated_at nor publishes an edit event for every post.
+ALTER TABLE posts DISABLE TRIGGER USER;
+UPDATE posts SET content_html = '<p>' || replace(
+    replace(replace(replace(replace(replace(replace(content,
+        E'\r\n', E'\n'),
+        '&', '&amp;'),
+        '<', '&lt;'),
+        '>', '&gt;'),
+        '''', '&#39;'),
+        '"', '&#34;'),
+    E'\n', E'<br>\n') || '</p>';
+ALTER TABLE posts ENABLE TRIGGER USER;
@@ -1,13 +0,0 @@
-package env
-
-import (
-	"os"
-)
-
-func GetString(key, fallback string) string {
-	val, exists := os.LookupEnv(key)
-	if !exists {
-		return fallback
-	}
-	return val
-}
@@ -0,0 +1,96 @@
+// Package events fans post events and notifications out to the streaming
+// clients of this replica. Both are produced by Postgres triggers and relayed
+// by Listen and ListenInbox, so every replica sees every change.
+package events
+
+import (
+	"devops/internal/metrics"
+	"devops/internal/store"
+	"sync"
+)
+
+// Subscription receives events on C until it is closed. C is closed when the
+// subscriber falls more than the broker's buffer behind, in which case Lagged
+// reports true and the client should resume from its last event.
+type Subscription struct {
+	C <-chan *store.PostEvent
+
+	ch     chan *store.PostEvent
+	broker *Broker
+	lagged bool
+}
+
+func (s *Subscription) Lagged() bool {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.lagged
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.remove(s)
+}
+
+// Broker delivers every published event to all current subscribers without
+// ever blocking the publisher on a slow client.
+type Broker struct {
+	mu     sync.Mutex
+	buffer int
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewBroker(buffer int) *Broker {
+	return &Broker{buffer: buffer, subs: make(map[*Subscription]struct{})}
+}
+
+func (b *Broker) Subscribe() *Subscription {
+	ch := make(chan *store.PostEvent, b.buffer)
+	sub := &Subscription{C: ch, ch: ch, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	metrics.StreamSubscribers.Inc()
+	return sub
+}
+
+func (b *Broker) Publish(event *store.PostEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.ch <- event:
+		default:
+			sub.lagged = true
+			metrics.StreamDropped.Inc()
+			b.remove(sub)
+		}
+	}
+}
+
+// Close disconnects every subscriber and rejects new ones. It is used on
+// shutdown so that open streams do not hold up draining the server.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
+
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.ch)
+	metrics.StreamSubscribers.Dec()
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"devops/internal/store"
+	"testing"
+)
+
+func TestBrokerFansOut(t *testing.T) {
+	b := NewBroker(4)
+	a, c := b.Subscribe(), b.Subscribe()
+	defer a.Close()
+	defer c.Close()
+
+	b.Publish(&store.PostEvent{ID: 1, Type: store.PostEventCreated})
+
+	for _, sub := range []*Subscription{a, c} {
+		if ev := <-sub.C; ev.ID != 1 {
+			t.Fatalf("Expected event 1, got %d", ev.ID)
+		}
+	}
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	b := NewBroker(1)
+	slow, fast := b.Subscribe(), b.Subscribe()
+	defer fast.Close()
+
+	b.Publish(&store.PostEvent{ID: 1})
+	<-fast.C
+	b.Publish(&store.PostEvent{ID: 2})
+	<-fast.C
+
+	if ev := <-slow.C; ev.ID != 1 {
+		t.Fatalf("Expected buffered event 1, got %d", ev.ID)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Fatal("Expected slow subscriber to be closed")
+	}
+	if !slow.Lagged() {
+		t.Fatal("Expected slow subscriber to be lagged")
+	}
+	if fast.Lagged() {
+		t.Fatal("Expected fast subscriber not to be lagged")
+	}
+	slow.Close()
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker(1)
+	sub := b.Subscribe()
+	b.Close()
+
+	if _, ok := <-sub.C; ok {
+		t.Fatal("Expected subscription to be closed")
+	}
+	if sub.Lagged() {
+		t.Fatal("Expected shutdown not to count as lag")
+	}
+	if _, ok := <-b.Subscribe().C; ok {
+		t.Fatal("Expected closed broker to hand out closed subscriptions")
+	}
+	sub.Close()
+}
+
+func TestInboxRoutesByUser(t *testing.T) {
+	in := NewInbox(1)
+	alice, bob := in.Subscribe(1), in.Subscribe(2)
+	defer alice.Close()
+	defer bob.Close()
+
+	in.Publish(&store.Notification{ID: 1, UserID: 1})
+	// Alice's buffer is full; the push is dropped rather than blocking.
+	in.Publish(&store.Notification{ID: 2, UserID: 1})
+
+	if n := <-alice.C; n.ID != 1 {
+		t.Fatalf("Expected notification 1, got %d", n.ID)
+	}
+	select {
+	case n := <-alice.C:
+		t.Fatalf("Expected overflow to be dropped, got %d", n.ID)
+	case n := <-bob.C:
+		t.Fatalf("Expected nothing for another user, got %d", n.ID)
+	default:
+	}
+
+	in.Close()
+	if _, ok := <-alice.C; ok {
+		t.Fatal("Expected subscription to be closed")
+	}
+}
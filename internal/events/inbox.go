@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"devops/internal/store"
+	"encoding/json"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// InboxChannel is the NOTIFY channel used by the notifications trigger.
+const InboxChannel = "notifications"
+
+// InboxSubscription receives the notifications of one user on C until it is
+// closed.
+type InboxSubscription struct {
+	C <-chan *store.Notification
+
+	ch     chan *store.Notification
+	userID int64
+	inbox  *Inbox
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *InboxSubscription) Close() {
+	s.inbox.mu.Lock()
+	defer s.inbox.mu.Unlock()
+	s.inbox.remove(s)
+}
+
+// Inbox routes new notifications to the open streams of their recipient.
+// Unlike post events they are not replayed, so a subscriber that is not
+// keeping up just misses pushes; the notifications stay in its inbox.
+type Inbox struct {
+	mu     sync.Mutex
+	buffer int
+	subs   map[int64]map[*InboxSubscription]struct{}
+	closed bool
+}
+
+func NewInbox(buffer int) *Inbox {
+	return &Inbox{buffer: buffer, subs: make(map[int64]map[*InboxSubscription]struct{})}
+}
+
+func (in *Inbox) Subscribe(userID int64) *InboxSubscription {
+	ch := make(chan *store.Notification, in.buffer)
+	sub := &InboxSubscription{C: ch, ch: ch, userID: userID, inbox: in}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.closed {
+		close(ch)
+		return sub
+	}
+	if in.subs[userID] == nil {
+		in.subs[userID] = make(map[*InboxSubscription]struct{})
+	}
+	in.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (in *Inbox) Publish(n *store.Notification) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for sub := range in.subs[n.UserID] {
+		select {
+		case sub.ch <- n:
+		default:
+		}
+	}
+}
+
+// Close disconnects every subscriber and rejects new ones.
+func (in *Inbox) Close() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.closed = true
+	for _, subs := range in.subs {
+		for sub := range subs {
+			in.remove(sub)
+		}
+	}
+}
+
+func (in *Inbox) remove(sub *InboxSubscription) {
+	subs := in.subs[sub.userID]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(in.subs, sub.userID)
+	}
+	close(sub.ch)
+}
+
+// inboxPayload is the body of an InboxChannel notification.
+type inboxPayload struct {
+	store.Notification
+	UserID int64 `json:"user_id"`
+}
+
+// ListenInbox relays new notifications to in until ctx is cancelled.
+// Notifications sent while the connection is down are not recovered.
+func ListenInbox(ctx context.Context, dsn string, in *Inbox, logger *zap.SugaredLogger) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warnw("notification listener connection problem", "event", ev, "error", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(InboxChannel); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := listener.Ping(); err != nil {
+				logger.Warnw("notification listener ping failed", "error", err)
+			}
+		case msg := <-listener.Notify:
+			if msg == nil {
+				continue
+			}
+			var p inboxPayload
+			if err := json.Unmarshal([]byte(msg.Extra), &p); err != nil {
+				logger.Errorw("failed to decode notification", "error", err)
+				continue
+			}
+			n := p.Notification
+			n.UserID = p.UserID
+			in.Publish(&n)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"devops/internal/store"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// Channel is the NOTIFY channel used by the posts trigger.
+	Channel = "post_events"
+
+	catchUpBatch = 500
+	pingInterval = 30 * time.Second
+)
+
+type Source interface {
+	ListSince(ctx context.Context, afterID int64, limit int) ([]*store.PostEvent, error)
+}
+
+// Listen relays events after last to b until ctx is cancelled, and returns
+// the id of the last event it relayed so that a restarted Listen resumes
+// from there. Notifications only carry the event id; events themselves are
+// read from src in id order, so nothing is lost when notifications are
+// dropped during a reconnect or while the listener is down.
+func Listen(ctx context.Context, dsn string, src Source, b *Broker, last int64, logger *zap.SugaredLogger) (int64, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warnw("post event listener connection problem", "event", ev, "error", err)
+		}
+	})
+	defer listener.Close()
+	if err := listener.Listen(Channel); err != nil {
+		return last, err
+	}
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		// Catch up before waiting, so events written before LISTEN took
+		// effect are not held back until the next notification.
+		last = catchUp(ctx, src, b, last, logger)
+
+		select {
+		case <-ctx.Done():
+			return last, nil
+		case <-listener.Notify:
+			// A nil notification means the connection was re-established
+			// and notifications may have been missed; catching up covers it.
+		case <-ticker.C:
+			if err := listener.Ping(); err != nil {
+				logger.Warnw("post event listener ping failed", "error", err)
+			}
+		}
+	}
+}
+
+// catchUp publishes every event after last and returns the new last id.
+func catchUp(ctx context.Context, src Source, b *Broker, last int64, logger *zap.SugaredLogger) int64 {
+	for {
+		events, err := src.ListSince(ctx, last, catchUpBatch)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Errorw("failed to read post events", "error", err)
+			}
+			return last
+		}
+		for _, event := range events {
+			b.Publish(event)
+			last = event.ID
+		}
+		if len(events) < catchUpBatch {
+			return last
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package events
+
+import (
+	"context"
+	"devops/internal/store"
+	"go.uber.org/zap"
+	"testing"
+)
+
+type eventLog []*store.PostEvent
+
+func (l eventLog) ListSince(_ context.Context, afterID int64, limit int) ([]*store.PostEvent, error) {
+	var events []*store.PostEvent
+	for _, event := range l {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func TestCatchUpResumesAfterLast(t *testing.T) {
+	var log eventLog
+	for id := int64(1); id <= catchUpBatch+2; id++ {
+		log = append(log, &store.PostEvent{ID: id})
+	}
+	b := NewBroker(len(log))
+	sub := b.Subscribe()
+	defer sub.Close()
+
+	last := catchUp(context.Background(), log, b, 1, zap.NewNop().Sugar())
+	if last != catchUpBatch+2 {
+		t.Fatalf("Expected to catch up to %d, got %d", catchUpBatch+2, last)
+	}
+	for want := int64(2); want <= last; want++ {
+		if ev := <-sub.C; ev.ID != want {
+			t.Fatalf("Expected event %d, got %d", want, ev.ID)
+		}
+	}
+
+	if again := catchUp(context.Background(), log, b, last, zap.NewNop().Sugar()); again != last {
+		t.Errorf("Expected no new events, got last %d", again)
+	}
+	if len(sub.C) != 0 {
+		t.Errorf("Expected nothing to be republished, got %d events", len(sub.C))
+	}
+}
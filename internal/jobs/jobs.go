@@ -0,0 +1,139 @@
+// Package jobs is a Postgres-backed background job queue.
+//
+// Jobs are rows in the jobs table, so they can be enqueued in the same
+// transaction as the change that needs them by passing the *sql.Tx to
+// Enqueue. Workers claim due jobs with FOR UPDATE SKIP LOCKED, which lets
+// any number of processes share the queue.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	mathrand "math/rand/v2"
+	"time"
+)
+
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateSucceeded = "succeeded"
+	StateFailed    = "failed"
+
+	defaultMaxAttempts = 10
+)
+
+var ErrNotFound = errors.New("job not found")
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload" swaggertype:"object"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Decode unmarshals the job payload into v.
+func (j *Job) Decode(v any) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type options struct {
+	runAt       time.Time
+	uniqueKey   string
+	maxAttempts int
+}
+
+type Option func(*options)
+
+// At schedules the job to run no earlier than t.
+func At(t time.Time) Option {
+	return func(o *options) { o.runAt = t }
+}
+
+// In schedules the job to run after d.
+func In(d time.Duration) Option {
+	return func(o *options) { o.runAt = time.Now().Add(d) }
+}
+
+// Unique drops the job if another job of the same kind and key is still
+// queued or running.
+func Unique(key string) Option {
+	return func(o *options) { o.uniqueKey = key }
+}
+
+// MaxAttempts overrides the number of attempts before the job is failed.
+func MaxAttempts(n int) Option {
+	return func(o *options) { o.maxAttempts = n }
+}
+
+// Enqueue adds a job of the given kind. Pass a *sql.Tx to enqueue atomically
+// with other writes. It reports false when a Unique job was already pending.
+func Enqueue(ctx context.Context, db Execer, kind string, payload any, opts ...Option) (bool, error) {
+	o := options{maxAttempts: defaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+	INSERT INTO jobs (kind, payload, max_attempts, run_at, unique_key)
+	VALUES ($1, $2, $3, COALESCE($4, NOW()), NULLIF($5, ''))
+	ON CONFLICT (kind, unique_key) WHERE unique_key IS NOT NULL AND state IN ('queued', 'running')
+	DO NOTHING;
+	`
+
+	var runAt *time.Time
+	if !o.runAt.IsZero() {
+		runAt = &o.runAt
+	}
+	res, err := db.ExecContext(ctx, query, kind, data, o.maxAttempts, runAt, o.uniqueKey)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Backoff returns the delay before retrying after the given failed attempt:
+// base doubled for each earlier attempt, capped at max, with the upper half
+// randomised so that failing work does not retry in lockstep.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return half + mathrand.N(half+1)
+}
+
+// permanentError marks an error that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the job fails immediately instead of being
+// retried, e.g. when its payload cannot be decoded.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	base, max := time.Second, 10*time.Second
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{10, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := Backoff(tt.attempt, base, max)
+			if got < tt.want/2 || got > tt.want {
+				t.Fatalf("Backoff(%d) = %s, expected between %s and %s", tt.attempt, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+// ErrDuplicate is returned when retrying a unique job whose key is already
+// taken by a pending copy.
+var ErrDuplicate = errors.New("a pending job with the same unique key exists")
+
+const jobColumns = `id, kind, payload, state, attempts, max_attempts, run_at,
+	COALESCE(unique_key, ''), last_error, created_at, updated_at, finished_at`
+
+// Filter narrows job listings. Zero values are ignored; Cursor is the last
+// seen job ID and pages backwards from the newest job.
+type Filter struct {
+	State  string
+	Kind   string
+	Cursor int64
+	Limit  int
+}
+
+// Queue is the handle used to enqueue and inspect jobs.
+type Queue struct {
+	db *sql.DB
+}
+
+func NewQueue(db *sql.DB) *Queue {
+	return &Queue{db: db}
+}
+
+// Enqueue adds a job outside of any transaction, see the package Enqueue.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any, opts ...Option) (bool, error) {
+	return Enqueue(ctx, q.db, kind, payload, opts...)
+}
+
+func (q *Queue) List(ctx context.Context, filter Filter) ([]*Job, error) {
+	query := `
+	SELECT ` + jobColumns + `
+	FROM jobs
+	WHERE ($1 = '' OR state = $1)
+		AND ($2 = '' OR kind = $2)
+		AND ($3 = 0 OR id < $3)
+	ORDER BY id DESC
+	LIMIT $4;
+	`
+	rows, err := q.db.QueryContext(ctx, query, filter.State, filter.Kind, filter.Cursor, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (q *Queue) Get(ctx context.Context, id int64) (*Job, error) {
+	row := q.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1;`, id)
+	job, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// Counts returns the number of jobs in each state.
+func (q *Queue) Counts(ctx context.Context) (map[string]int64, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT state, COUNT(*) FROM jobs GROUP BY state;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{StateQueued: 0, StateRunning: 0, StateSucceeded: 0, StateFailed: 0}
+	for rows.Next() {
+		var state string
+		var n int64
+		if err := rows.Scan(&state, &n); err != nil {
+			return nil, err
+		}
+		counts[state] = n
+	}
+	return counts, rows.Err()
+}
+
+// Retry queues a failed job to run again now with a fresh attempt budget.
+func (q *Queue) Retry(ctx context.Context, id int64) error {
+	query := `
+	UPDATE jobs SET
+		state = 'queued', attempts = 0, run_at = NOW(), finished_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND state = 'failed';
+	`
+	res, err := q.db.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicate
+		}
+		return err
+	}
+	return expectOne(res)
+}
+
+// Delete discards a job that is not running.
+func (q *Queue) Delete(ctx context.Context, id int64) error {
+	res, err := q.db.ExecContext(ctx, `DELETE FROM jobs WHERE id = $1 AND state <> 'running';`, id)
+	if err != nil {
+		return err
+	}
+	return expectOne(res)
+}
+
+// claim takes the oldest due job of one of kinds and marks it running until
+// lease expires. It returns nil when nothing is due.
+func (q *Queue) claim(ctx context.Context, kinds []string, lease time.Duration) (*Job, error) {
+	query := `
+	UPDATE jobs SET
+		state = 'running',
+		attempts = attempts + 1,
+		locked_until = NOW() + make_interval(secs => $2),
+		updated_at = NOW()
+	WHERE id = (
+		SELECT id
+		FROM jobs
+		WHERE state = 'queued' AND run_at <= NOW() AND kind = ANY($1)
+		ORDER BY run_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + jobColumns + `;
+	`
+	job, err := scanJob(q.db.QueryRowContext(ctx, query, pq.Array(kinds), lease.Seconds()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+func (q *Queue) complete(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, `
+	UPDATE jobs SET
+		state = 'succeeded', locked_until = NULL, last_error = '', finished_at = NOW(), updated_at = NOW()
+	WHERE id = $1;
+	`, id)
+	return err
+}
+
+// fail records a failed attempt. The job is retried at retryAt, or marked
+// failed when retryAt is zero.
+func (q *Queue) fail(ctx context.Context, id int64, msg string, retryAt time.Time) error {
+	query := `
+	UPDATE jobs SET
+		state = CASE WHEN $3::timestamptz IS NULL THEN 'failed' ELSE 'queued' END,
+		run_at = COALESCE($3, run_at),
+		finished_at = CASE WHEN $3::timestamptz IS NULL THEN NOW() END,
+		locked_until = NULL,
+		last_error = $2,
+		updated_at = NOW()
+	WHERE id = $1;
+	`
+	var at *time.Time
+	if !retryAt.IsZero() {
+		at = &retryAt
+	}
+	_, err := q.db.ExecContext(ctx, query, id, msg, at)
+	return err
+}
+
+// release hands back a job interrupted by shutdown without using up an
+// attempt.
+func (q *Queue) release(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, `
+	UPDATE jobs SET
+		state = 'queued', attempts = attempts - 1, locked_until = NULL, updated_at = NOW()
+	WHERE id = $1;
+	`, id)
+	return err
+}
+
+// rescue requeues running jobs whose lease has lapsed because their worker
+// died, or fails them when they are out of attempts.
+func (q *Queue) rescue(ctx context.Context) (int64, error) {
+	res, err := q.db.ExecContext(ctx, `
+	UPDATE jobs SET
+		state = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'queued' END,
+		finished_at = CASE WHEN attempts >= max_attempts THEN NOW() END,
+		locked_until = NULL,
+		last_error = 'worker lease expired',
+		updated_at = NOW()
+	WHERE state = 'running' AND locked_until < NOW();
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// prune deletes succeeded jobs that finished before t. Failed jobs are kept
+// until they are retried or deleted.
+func (q *Queue) prune(ctx context.Context, t time.Time) (int64, error) {
+	res, err := q.db.ExecContext(ctx, `DELETE FROM jobs WHERE state = 'succeeded' AND finished_at < $1;`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// scheduleDue enqueues kind if its schedule is due and advances the schedule
+// to next. Replicas race on the schedule row; exactly one of them enqueues.
+func (q *Queue) scheduleDue(ctx context.Context, kind string, payload any, next time.Time) (bool, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO job_schedules (kind, next_run_at) VALUES ($1, $2)
+	ON CONFLICT (kind) DO NOTHING;
+	`, kind, next)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `
+	UPDATE job_schedules SET next_run_at = $2
+	WHERE kind = $1 AND next_run_at <= NOW();
+	`, kind, next)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	// Unique so that a slow run is not stacked with the next one.
+	enqueued, err := Enqueue(ctx, tx, kind, payload, Unique("schedule"), MaxAttempts(3))
+	if err != nil {
+		return false, err
+	}
+	return enqueued, tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	job := &Job{}
+	var payload []byte
+	err := row.Scan(
+		&job.ID,
+		&job.Kind,
+		&payload,
+		&job.State,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.UniqueKey,
+		&job.LastError,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = payload
+	return job, nil
+}
+
+func expectOne(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package jobs
+
+import (
+	"context"
+	"devops/internal/config"
+	"devops/internal/metrics"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	// leaseMargin is added to the job timeout when claiming, so a job that
+	// runs right up to its timeout is not rescued while still finishing.
+	leaseMargin         = 30 * time.Second
+	maintenanceInterval = 15 * time.Second
+	pruneInterval       = time.Hour
+	maxErrorLength      = 2000
+)
+
+// Handler runs one job. Returning an error retries the job with backoff,
+// unless it is wrapped with Permanent or the job is out of attempts.
+type Handler func(ctx context.Context, job *Job) error
+
+type schedule struct {
+	kind    string
+	spec    cron.Schedule
+	payload any
+}
+
+// Worker runs the handlers registered with Handle and enqueues the jobs
+// registered with Schedule.
+type Worker struct {
+	queue     *Queue
+	cfg       config.Jobs
+	logger    *zap.SugaredLogger
+	handlers  map[string]Handler
+	schedules []schedule
+}
+
+func NewWorker(queue *Queue, cfg config.Jobs, logger *zap.SugaredLogger) *Worker {
+	return &Worker{
+		queue:    queue,
+		cfg:      cfg,
+		logger:   logger,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for kind. It must be called before Run.
+func (w *Worker) Handle(kind string, h Handler) {
+	w.handlers[kind] = h
+}
+
+// Schedule enqueues kind with payload on a standard five-field cron spec,
+// or a descriptor such as "@hourly" or "@every 10m". It must be called
+// before Run.
+func (w *Worker) Schedule(kind, spec string, payload any) error {
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", kind, err)
+	}
+	w.schedules = append(w.schedules, schedule{kind: kind, spec: s, payload: payload})
+	return nil
+}
+
+// Run processes jobs until ctx is cancelled. Jobs still running at that
+// point see their context cancelled and are handed back to the queue.
+func (w *Worker) Run(ctx context.Context) {
+	kinds := make([]string, 0, len(w.handlers))
+	for kind := range w.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	var wg sync.WaitGroup
+	for range w.cfg.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work(ctx, kinds)
+		}()
+	}
+	w.maintain(ctx)
+	wg.Wait()
+}
+
+func (w *Worker) work(ctx context.Context, kinds []string) {
+	for {
+		job, err := w.queue.claim(ctx, kinds, w.cfg.Timeout+leaseMargin)
+		if err != nil && ctx.Err() == nil {
+			w.logger.Errorw("failed to claim job", "error", err)
+		}
+		if job != nil {
+			w.run(ctx, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.cfg.PollInterval):
+		}
+	}
+}
+
+func (w *Worker) run(ctx context.Context, job *Job) {
+	start := time.Now()
+	jobCtx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	err := w.call(jobCtx, job)
+	cancel()
+	metrics.JobDuration.WithLabelValues(job.Kind).Observe(time.Since(start).Seconds())
+
+	// The outcome must be recorded even when ctx has been cancelled.
+	done := context.WithoutCancel(ctx)
+	log := w.logger.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+	var result string
+	switch {
+	case err == nil:
+		result = StateSucceeded
+		err = w.queue.complete(done, job.ID)
+	case ctx.Err() != nil:
+		result = "released"
+		err = w.queue.release(done, job.ID)
+	default:
+		msg := err.Error()
+		if len(msg) > maxErrorLength {
+			msg = msg[:maxErrorLength]
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+			result = StateFailed
+			log.Errorw("job failed", "error", err)
+			err = w.queue.fail(done, job.ID, msg, time.Time{})
+		} else {
+			result = "retried"
+			retryAt := time.Now().Add(Backoff(job.Attempts, w.cfg.BackoffBase, w.cfg.BackoffMax))
+			log.Warnw("job attempt failed, will retry", "error", err, "retry_at", retryAt)
+			err = w.queue.fail(done, job.ID, msg, retryAt)
+		}
+	}
+	metrics.JobsProcessed.WithLabelValues(job.Kind, result).Inc()
+	if err != nil {
+		log.Errorw("failed to record job outcome", "result", result, "error", err)
+	}
+}
+
+// call runs the job's handler, turning a panic into an error.
+func (w *Worker) call(ctx context.Context, job *Job) (err error) {
+	h, ok := w.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// maintain enqueues scheduled jobs, rescues jobs abandoned by dead workers
+// and prunes old succeeded jobs until ctx is cancelled.
+func (w *Worker) maintain(ctx context.Context) {
+	tick := time.NewTicker(maintenanceInterval)
+	defer tick.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	w.enqueueScheduled(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			w.enqueueScheduled(ctx)
+			if n, err := w.queue.rescue(ctx); err != nil && ctx.Err() == nil {
+				w.logger.Errorw("failed to rescue stalled jobs", "error", err)
+			} else if n > 0 {
+				w.logger.Warnw("rescued stalled jobs", "count", n)
+			}
+		case <-prune.C:
+			if n, err := w.queue.prune(ctx, time.Now().Add(-w.cfg.Retention)); err != nil && ctx.Err() == nil {
+				w.logger.Errorw("failed to prune jobs", "error", err)
+			} else if n > 0 {
+				w.logger.Infow("pruned finished jobs", "count", n)
+			}
+		}
+	}
+}
+
+func (w *Worker) enqueueScheduled(ctx context.Context) {
+	for _, s := range w.schedules {
+		if _, err := w.queue.scheduleDue(ctx, s.kind, s.payload, s.spec.Next(time.Now())); err != nil && ctx.Err() == nil {
+			w.logger.Errorw("failed to enqueue scheduled job", "kind", s.kind, "error", err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package jobs
+
+import (
+	"context"
+	"devops/internal/config"
+	"errors"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestCallRecoversPanics(t *testing.T) {
+	w := &Worker{handlers: map[string]Handler{
+		"boom": func(context.Context, *Job) error { panic("boom") },
+	}}
+
+	if err := w.call(context.Background(), &Job{Kind: "boom"}); err == nil {
+		t.Error("Expected a panicking handler to return an error")
+	}
+}
+
+func TestCallUnknownKindIsPermanent(t *testing.T) {
+	w := &Worker{handlers: map[string]Handler{}}
+
+	err := w.call(context.Background(), &Job{Kind: "missing"})
+	var permanent *permanentError
+	if !errors.As(err, &permanent) {
+		t.Errorf("Expected a permanent error, got %v", err)
+	}
+}
+
+func TestScheduleRejectsInvalidSpec(t *testing.T) {
+	w := NewWorker(nil, config.Jobs{}, zap.NewNop().Sugar())
+	if err := w.Schedule("prune", "every hour", nil); err == nil {
+		t.Error("Expected an invalid spec to be rejected")
+	}
+	if err := w.Schedule("prune", "@every 10m", nil); err != nil {
+		t.Errorf("Expected @every to be accepted, got %v", err)
+	}
+	if err := w.Schedule("prune", "0 3 * * 1", nil); err != nil {
+		t.Errorf("Expected a five-field spec to be accepted, got %v", err)
+	}
+}
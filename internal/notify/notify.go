@@ -0,0 +1,208 @@
+// Package notify tells users about activity that concerns them. A
+// notification lands in the recipient's in-app inbox and is enqueued as a
+// background job; the job applies the recipient's preferences and either
+// emails it at once or holds it for the periodic digest.
+package notify
+
+import (
+	"context"
+	"devops/internal/jobs"
+	"devops/internal/store"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Notification kinds. Add a kind together with the feature that produces
+// it, since every kind needs templates and is a valid muted_kinds value.
+const (
+	KindMention    = "mention"
+	KindModeration = "moderation"
+)
+
+// Kinds lists every notification kind.
+var Kinds = []string{KindMention, KindModeration}
+
+// Job kinds handled by Service.
+const (
+	JobEmail  = "notify.email"
+	JobDigest = "notify.digest"
+)
+
+// Notification is addressed to one user. Data holds the kind-specific
+// template fields, e.g. post_id, post_title, actor and reason.
+type Notification struct {
+	Kind   string            `json:"kind"`
+	UserID int64             `json:"user_id"`
+	Data   map[string]string `json:"data"`
+}
+
+// Enqueue adds n to the recipient's inbox and schedules it for email. Pass a
+// *sql.Tx to do both atomically with the change that caused it.
+func Enqueue(ctx context.Context, db jobs.Execer, n *Notification) error {
+	data, err := json.Marshal(n.Data)
+	if err != nil {
+		return err
+	}
+	if err := store.AddToInbox(ctx, db, n.UserID, n.Kind, data); err != nil {
+		return err
+	}
+	_, err = jobs.Enqueue(ctx, db, JobEmail, n)
+	return err
+}
+
+type Users interface {
+	GetUserByID(ctx context.Context, id int64) (*store.User, error)
+}
+
+type Preferences interface {
+	GetPreferences(ctx context.Context, userID int64) (*store.NotificationPreferences, error)
+	AddDigestItem(ctx context.Context, item *store.DigestItem) error
+	DigestRecipients(ctx context.Context) ([]int64, error)
+	DrainDigest(ctx context.Context, userID int64, fn func([]*store.DigestItem) error) error
+}
+
+// Service runs the notification jobs.
+type Service struct {
+	users    Users
+	prefs    Preferences
+	mailer   Mailer
+	renderer *Renderer
+	baseURL  string
+}
+
+// NewService returns a Service that sends mail through mailer. A nil mailer
+// disables email; notifications are then dropped.
+func NewService(users Users, prefs Preferences, mailer Mailer, renderer *Renderer, baseURL string) *Service {
+	return &Service{users: users, prefs: prefs, mailer: mailer, renderer: renderer, baseURL: baseURL}
+}
+
+// HandleEmail is the JobEmail handler.
+func (s *Service) HandleEmail(ctx context.Context, job *jobs.Job) error {
+	var n Notification
+	if err := job.Decode(&n); err != nil {
+		return jobs.Permanent(err)
+	}
+	if s.mailer == nil {
+		return nil
+	}
+
+	prefs, err := s.prefs.GetPreferences(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	if prefs.Muted(n.Kind) {
+		return nil
+	}
+	switch prefs.Email {
+	case store.EmailOff:
+		return nil
+	case store.EmailDigest:
+		data, err := json.Marshal(n.Data)
+		if err != nil {
+			return jobs.Permanent(err)
+		}
+		return s.prefs.AddDigestItem(ctx, &store.DigestItem{UserID: n.UserID, Kind: n.Kind, Data: data})
+	}
+
+	user, err := s.users.GetUserByID(ctx, n.UserID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	msg, err := s.renderer.Render(n.Kind, s.emailData(user, &n))
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	msg.To = user.Email
+	return s.mailer.Send(ctx, msg)
+}
+
+// HandleDigest is the JobDigest handler. It sends one email per user with
+// notifications waiting; a user whose email fails keeps their items for the
+// next run.
+func (s *Service) HandleDigest(ctx context.Context, _ *jobs.Job) error {
+	if s.mailer == nil {
+		return nil
+	}
+	recipients, err := s.prefs.DigestRecipients(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, userID := range recipients {
+		if err := s.sendDigest(ctx, userID); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", userID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Service) sendDigest(ctx context.Context, userID int64) error {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return s.prefs.DrainDigest(ctx, userID, func(items []*store.DigestItem) error {
+		if user == nil {
+			// The items go with the deleted account.
+			return nil
+		}
+		data := s.emailData(user, nil)
+		for _, item := range items {
+			n := &Notification{Kind: item.Kind, UserID: userID}
+			if err := json.Unmarshal(item.Data, &n.Data); err != nil {
+				return err
+			}
+			summary, err := s.renderer.Summary(n.Kind, s.emailData(user, n))
+			if err != nil {
+				return err
+			}
+			data.Items = append(data.Items, DigestLine{Summary: summary, URL: data.postURL(n), Time: item.CreatedAt})
+		}
+		msg, err := s.renderer.Render(digestTemplate, data)
+		if err != nil {
+			return err
+		}
+		msg.To = user.Email
+		return s.mailer.Send(ctx, msg)
+	})
+}
+
+// EmailData is the data passed to every email template.
+type EmailData struct {
+	Username     string
+	BaseURL      string
+	URL          string
+	Notification *Notification
+	Items        []DigestLine
+}
+
+// DigestLine is one notification in a digest.
+type DigestLine struct {
+	Summary string
+	URL     string
+	Time    time.Time
+}
+
+func (s *Service) emailData(user *store.User, n *Notification) *EmailData {
+	data := &EmailData{Username: user.Username, BaseURL: s.baseURL, Notification: n}
+	if data.Username == "" {
+		data.Username = user.Email
+	}
+	if n != nil {
+		data.URL = data.postURL(n)
+	}
+	return data
+}
+
+func (d *EmailData) postURL(n *Notification) string {
+	if id, err := strconv.ParseInt(n.Data["post_id"], 10, 64); err == nil {
+		return fmt.Sprintf("%s/posts/%d", d.BaseURL, id)
+	}
+	return d.BaseURL
+}
@@ -0,0 +1,226 @@
+package notify
+
+import (
+	"context"
+	"devops/internal/jobs"
+	"devops/internal/store"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeUsers map[int64]*store.User
+
+func (f fakeUsers) GetUserByID(_ context.Context, id int64) (*store.User, error) {
+	if u, ok := f[id]; ok {
+		return u, nil
+	}
+	return nil, store.ErrNotFound
+}
+
+type fakePreferences struct {
+	prefs  map[int64]*store.NotificationPreferences
+	digest []*store.DigestItem
+}
+
+func (f *fakePreferences) GetPreferences(_ context.Context, userID int64) (*store.NotificationPreferences, error) {
+	if p, ok := f.prefs[userID]; ok {
+		return p, nil
+	}
+	return &store.NotificationPreferences{UserID: userID, Email: store.EmailInstant}, nil
+}
+
+func (f *fakePreferences) AddDigestItem(_ context.Context, item *store.DigestItem) error {
+	item.CreatedAt = time.Now()
+	f.digest = append(f.digest, item)
+	return nil
+}
+
+func (f *fakePreferences) DigestRecipients(context.Context) ([]int64, error) {
+	seen := map[int64]bool{}
+	var ids []int64
+	for _, item := range f.digest {
+		if !seen[item.UserID] {
+			seen[item.UserID] = true
+			ids = append(ids, item.UserID)
+		}
+	}
+	return ids, nil
+}
+
+func (f *fakePreferences) DrainDigest(_ context.Context, userID int64, fn func([]*store.DigestItem) error) error {
+	var mine, rest []*store.DigestItem
+	for _, item := range f.digest {
+		if item.UserID == userID {
+			mine = append(mine, item)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	if len(mine) == 0 {
+		return nil
+	}
+	if err := fn(mine); err != nil {
+		return err
+	}
+	f.digest = rest
+	return nil
+}
+
+type fakeMailer struct {
+	sent []*Message
+}
+
+func (f *fakeMailer) Send(_ context.Context, msg *Message) error {
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func newTestService(t *testing.T, prefs *fakePreferences) (*Service, *fakeMailer) {
+	t.Helper()
+	renderer, err := NewRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &fakeMailer{}
+	users := fakeUsers{1: {ID: 1, Username: "alice", Email: "alice@example.com"}}
+	return NewService(users, prefs, mailer, renderer, "http://app.test"), mailer
+}
+
+func emailJob(t *testing.T, n *Notification) *jobs.Job {
+	t.Helper()
+	payload, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jobs.Job{Kind: JobEmail, Payload: payload}
+}
+
+func moderation(title string) *Notification {
+	return &Notification{Kind: KindModeration, UserID: 1, Data: map[string]string{
+		"post_id": "7", "post_title": title, "action": "hidden", "reason": "spam",
+	}}
+}
+
+func TestHandleEmailInstant(t *testing.T) {
+	svc, mailer := newTestService(t, &fakePreferences{})
+
+	if err := svc.HandleEmail(context.Background(), emailJob(t, moderation("<b>Hi</b>"))); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(mailer.sent))
+	}
+	msg := mailer.sent[0]
+	if msg.To != "alice@example.com" {
+		t.Errorf("Expected email to alice, got %q", msg.To)
+	}
+	if msg.Subject != `Your post "<b>Hi</b>" was hidden` {
+		t.Errorf("Unexpected subject %q", msg.Subject)
+	}
+	if strings.Contains(msg.HTML, "<b>Hi</b>") {
+		t.Error("Expected user content to be escaped in the HTML part")
+	}
+	for _, want := range []string{"Reason: spam", "http://app.test/posts/7"} {
+		if !strings.Contains(msg.Text, want) {
+			t.Errorf("Expected %q in text part, got %q", want, msg.Text)
+		}
+	}
+}
+
+func TestHandleEmailRespectsPreferences(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefs      *store.NotificationPreferences
+		wantDigest int
+	}{
+		{"off", &store.NotificationPreferences{Email: store.EmailOff}, 0},
+		{"muted kind", &store.NotificationPreferences{Email: store.EmailInstant, MutedKinds: []string{KindModeration}}, 0},
+		{"digest", &store.NotificationPreferences{Email: store.EmailDigest}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := &fakePreferences{prefs: map[int64]*store.NotificationPreferences{1: tt.prefs}}
+			svc, mailer := newTestService(t, prefs)
+
+			if err := svc.HandleEmail(context.Background(), emailJob(t, moderation("Post"))); err != nil {
+				t.Fatal(err)
+			}
+			if len(mailer.sent) != 0 {
+				t.Errorf("Expected no email, got %d", len(mailer.sent))
+			}
+			if len(prefs.digest) != tt.wantDigest {
+				t.Errorf("Expected %d digest items, got %d", tt.wantDigest, len(prefs.digest))
+			}
+		})
+	}
+}
+
+func TestHandleDigest(t *testing.T) {
+	prefs := &fakePreferences{prefs: map[int64]*store.NotificationPreferences{1: {Email: store.EmailDigest}}}
+	svc, mailer := newTestService(t, prefs)
+	for _, title := range []string{"First", "Second"} {
+		if err := svc.HandleEmail(context.Background(), emailJob(t, moderation(title))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := svc.HandleDigest(context.Background(), &jobs.Job{Kind: JobDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("Expected 1 digest email, got %d", len(mailer.sent))
+	}
+	msg := mailer.sent[0]
+	if msg.Subject != "You have 2 new notifications" {
+		t.Errorf("Unexpected subject %q", msg.Subject)
+	}
+	for _, want := range []string{`Your post "First" was hidden`, `Your post "Second" was hidden`} {
+		if !strings.Contains(msg.Text, want) {
+			t.Errorf("Expected %q in digest, got %q", want, msg.Text)
+		}
+	}
+	if len(prefs.digest) != 0 {
+		t.Errorf("Expected the digest to be drained, %d items left", len(prefs.digest))
+	}
+}
+
+func TestRendererCoversEveryKind(t *testing.T) {
+	renderer, err := NewRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range Kinds {
+		n := &Notification{Kind: kind, Data: map[string]string{"actor": "bob", "post_title": "Post"}}
+		msg, err := renderer.Render(kind, &EmailData{Username: "alice", Notification: n})
+		if err != nil {
+			t.Errorf("%s: %v", kind, err)
+			continue
+		}
+		if msg.Subject == "" || msg.Text == "" || msg.HTML == "" {
+			t.Errorf("%s: expected subject and both bodies, got %+v", kind, msg)
+		}
+	}
+}
+
+func TestSMTPMailerEncode(t *testing.T) {
+	m, err := NewSMTPMailer("localhost:1025", "", "", "Devops <no-reply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := m.encode(&Message{To: "alice@example.com", Subject: "Grüße", Text: "plain", HTML: "<p>html</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=",
+		"Content-Type: multipart/alternative",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Type: text/html; charset=utf-8",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected %q in message, got:\n%s", want, body)
+		}
+	}
+}
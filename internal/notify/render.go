@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+const digestTemplate = "digest"
+
+// Renderer renders the embedded email templates. Each name has a .txt file
+// defining "subject", "summary" (the line used in digests) and "body", and
+// a .html file defining "body"; both are wrapped in the matching layout.
+type Renderer struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+func NewRenderer() (*Renderer, error) {
+	r := &Renderer{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+	for _, name := range append([]string{digestTemplate}, Kinds...) {
+		t, err := texttemplate.ParseFS(templateFS, "templates/layout.txt", "templates/"+name+".txt")
+		if err != nil {
+			return nil, err
+		}
+		h, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+		if err != nil {
+			return nil, err
+		}
+		r.text[name], r.html[name] = t.Option("missingkey=zero"), h.Option("missingkey=zero")
+	}
+	return r, nil
+}
+
+// Render renders the email for a notification kind or the digest.
+func (r *Renderer) Render(name string, data *EmailData) (*Message, error) {
+	t, h := r.text[name], r.html[name]
+	if t == nil || h == nil {
+		return nil, &unknownTemplateError{name}
+	}
+	subject, err := execute(t, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	text, err := execute(t, "layout", data)
+	if err != nil {
+		return nil, err
+	}
+	var html bytes.Buffer
+	if err := h.ExecuteTemplate(&html, "layout", data); err != nil {
+		return nil, err
+	}
+	// Subjects include user content; keep them on one line.
+	return &Message{Subject: strings.Join(strings.Fields(subject), " "), Text: text, HTML: html.String()}, nil
+}
+
+// Summary renders the one-line digest entry for a notification.
+func (r *Renderer) Summary(kind string, data *EmailData) (string, error) {
+	t := r.text[kind]
+	if t == nil {
+		return "", &unknownTemplateError{kind}
+	}
+	summary, err := execute(t, "summary", data)
+	return strings.Join(strings.Fields(summary), " "), err
+}
+
+func execute(t *texttemplate.Template, name string, data any) (string, error) {
+	var buf bytes.Buffer
+	err := t.ExecuteTemplate(&buf, name, data)
+	return buf.String(), err
+}
+
+type unknownTemplateError struct{ name string }
+
+func (e *unknownTemplateError) Error() string {
+	return "notify: no template for " + e.name
+}
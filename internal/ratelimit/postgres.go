@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const queryTimeout = time.Second
+
+// Postgres keeps buckets in the rate_limit_buckets table so that every
+// replica sharing the database enforces the same limits.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (s *Postgres) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+	VALUES ($1, $2, now())
+	ON CONFLICT (key) DO NOTHING;
+	`, key, p.Limit)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var tokens, elapsed float64
+	err = tx.QueryRowContext(ctx, `
+	SELECT tokens, EXTRACT(EPOCH FROM now() - updated_at)
+	FROM rate_limit_buckets
+	WHERE key = $1
+	FOR UPDATE;
+	`, key).Scan(&tokens, &elapsed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens, res := take(tokens, seconds(elapsed), p)
+	_, err = tx.ExecContext(ctx, `
+	UPDATE rate_limit_buckets SET tokens = $2, updated_at = now()
+	WHERE key = $1;
+	`, key, tokens)
+	if err != nil {
+		return Result{}, err
+	}
+	return res, tx.Commit()
+}
+
+func (s *Postgres) Sweep(ctx context.Context, idle time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+	DELETE FROM rate_limit_buckets
+	WHERE updated_at < now() - make_interval(secs => $1);
+	`, idle.Seconds())
+	return err
+}
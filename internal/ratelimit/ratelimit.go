@@ -0,0 +1,92 @@
+// Package ratelimit implements token-bucket rate limiting with pluggable
+// storage so that limits can be kept per process or shared across replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy allows Limit requests per Period. Tokens refill continuously, so a
+// client that has used its burst regains one request every Period/Limit.
+type Policy struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParsePolicy parses the "limit/period" form used in configuration,
+// e.g. "60/1m".
+func ParsePolicy(s string) (Policy, error) {
+	limit, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("rate limit policy %q: expected limit/period", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || n <= 0 {
+		return Policy{}, fmt.Errorf("rate limit policy %q: limit must be a positive integer", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("rate limit policy %q: period must be a positive duration", s)
+	}
+	return Policy{Limit: n, Period: d}, nil
+}
+
+func (p Policy) String() string {
+	return strconv.Itoa(p.Limit) + "/" + p.Period.String()
+}
+
+// rate is the refill rate in tokens per second.
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result describes the outcome of a single Take.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request would be allowed. It is
+	// zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+// Store holds the buckets. Implementations must make Take atomic per key.
+type Store interface {
+	// Take consumes one token from the bucket for key under policy p.
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+	// Sweep drops buckets that have not been touched for idle. A bucket idle
+	// for at least its period is full, so dropping it changes nothing.
+	Sweep(ctx context.Context, idle time.Duration) error
+}
+
+// take refills a bucket holding tokens after elapsed and tries to consume one
+// token, returning the new token count.
+func take(tokens float64, elapsed time.Duration, p Policy) (float64, Result) {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	rate := p.rate()
+	tokens = math.Min(float64(p.Limit), tokens+elapsed.Seconds()*rate)
+
+	res := Result{Limit: p.Limit}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = seconds((float64(p.Limit) - tokens) / rate)
+	return tokens, res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("60/1m")
+	if err != nil {
+		t.Fatalf("ParsePolicy returned error: %v", err)
+	}
+	if p.Limit != 60 || p.Period != time.Minute {
+		t.Errorf("Expected 60/1m, got %v", p)
+	}
+
+	for _, s := range []string{"", "60", "0/1m", "-1/1m", "x/1m", "10/0s", "10/soon"} {
+		if _, err := ParsePolicy(s); err == nil {
+			t.Errorf("Expected ParsePolicy(%q) to fail", s)
+		}
+	}
+}
+
+func TestMemoryTake(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	p := Policy{Limit: 3, Period: 3 * time.Second}
+
+	for i := 2; i >= 0; i-- {
+		res, _ := m.Take(ctx, "k", p)
+		if !res.Allowed || res.Remaining != i {
+			t.Fatalf("Expected allowed with %d remaining, got %+v", i, res)
+		}
+	}
+
+	res, _ := m.Take(ctx, "k", p)
+	if res.Allowed {
+		t.Fatal("Expected fourth request to be limited")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("Expected retry after 1s, got %v", res.RetryAfter)
+	}
+	if res.Reset != 3*time.Second {
+		t.Errorf("Expected reset after 3s, got %v", res.Reset)
+	}
+
+	if res, _ := m.Take(ctx, "other", p); !res.Allowed {
+		t.Error("Expected buckets to be independent per key")
+	}
+
+	now = now.Add(time.Second)
+	if res, _ := m.Take(ctx, "k", p); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("Expected one refilled token, got %+v", res)
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	p := Policy{Limit: 1, Period: time.Minute}
+
+	m.Take(ctx, "old", p)
+	now = now.Add(2 * time.Minute)
+	m.Take(ctx, "new", p)
+	m.Sweep(ctx, time.Minute)
+
+	if _, ok := m.buckets["old"]; ok {
+		t.Error("Expected idle bucket to be swept")
+	}
+	if _, ok := m.buckets["new"]; !ok {
+		t.Error("Expected recent bucket to be kept")
+	}
+}
@@ -0,0 +1,239 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	AuditPostCreate = "post.create"
+	AuditPostEdit   = "post.edit"
+	AuditPostDelete = "post.delete"
+	AuditAuthLogin  = "auth.login"
+
+	AuditWebhookCreate    = "webhook.create"
+	AuditWebhookUpdate    = "webhook.update"
+	AuditWebhookDelete    = "webhook.delete"
+	AuditWebhookRedeliver = "webhook.redeliver"
+
+	AuditJobRetry  = "job.retry"
+	AuditJobDelete = "job.delete"
+)
+
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	ActorEmail string          `json:"actor_email,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	RequestID  string          `json:"request_id"`
+	IP         string          `json:"ip"`
+	Diff       json.RawMessage `json:"diff" swaggertype:"object"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows audit queries. Zero values are ignored; Cursor is the
+// last seen event ID and pages backwards from the newest event.
+type AuditFilter struct {
+	ActorEmail string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+	Cursor     int64
+	Limit      int
+}
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func (s *AuditStore) Create(ctx context.Context, event *AuditEvent) (err error) {
+	ctx, q := startQuery(ctx, "audit", "Create")
+	defer q.end(&err)
+
+	query := `
+	INSERT INTO audit_events (actor_email, action, target_type, target_id, request_id, ip, diff)
+	VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7) RETURNING id, created_at
+	`
+
+	if len(event.Diff) == 0 {
+		event.Diff = json.RawMessage(`{}`)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, query,
+		event.ActorEmail, event.Action, event.TargetType, event.TargetID,
+		event.RequestID, event.IP, []byte(event.Diff)).Scan(
+		&event.ID,
+		&event.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+func (s *AuditStore) List(ctx context.Context, filter AuditFilter) (_ []*AuditEvent, err error) {
+	ctx, q := startQuery(ctx, "audit", "List")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var events []*AuditEvent
+	err = s.query(ctx, filter, func(event *AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.setRows(int64(len(events)))
+	return events, nil
+}
+
+// Export streams every event matching the filter to fn. It is not bound by
+// QueryTimeOut so large exports are only limited by the request context.
+func (s *AuditStore) Export(ctx context.Context, filter AuditFilter, fn func(*AuditEvent) error) (err error) {
+	ctx, q := startQuery(ctx, "audit", "Export")
+	defer q.end(&err)
+
+	filter.Limit = 0
+	var n int64
+	err = s.query(ctx, filter, func(event *AuditEvent) error {
+		n++
+		return fn(event)
+	})
+	q.setRows(n)
+	return err
+}
+
+func (s *AuditStore) query(ctx context.Context, filter AuditFilter, fn func(*AuditEvent) error) error {
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.ActorEmail != "" {
+		add("actor_email = $%d", filter.ActorEmail)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.TargetType != "" {
+		add("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id = $%d", filter.TargetID)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < $%d", filter.To)
+	}
+	if filter.Cursor > 0 {
+		add("id < $%d", filter.Cursor)
+	}
+
+	query := `
+	SELECT id, COALESCE(actor_email, ''), action, target_type, target_id, request_id, ip, diff, created_at
+	FROM audit_events`
+	if len(where) > 0 {
+		query += "\n\tWHERE " + strings.Join(where, " AND ")
+	}
+	query += "\n\tORDER BY id DESC"
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf("\n\tLIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &AuditEvent{}
+		var diff []byte
+		err = rows.Scan(
+			&event.ID,
+			&event.ActorEmail,
+			&event.Action,
+			&event.TargetType,
+			&event.TargetID,
+			&event.RequestID,
+			&event.IP,
+			&diff,
+			&event.CreatedAt)
+		if err != nil {
+			return err
+		}
+		event.Diff = diff
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// AuditChange is a single field change recorded in an audit diff.
+type AuditChange struct {
+	Old any `json:"old,omitempty"`
+	New any `json:"new,omitempty"`
+}
+
+// Diff returns the JSON-encoded field changes between two values of the same
+// type. Either side may be nil to record a creation or deletion.
+func Diff(before, after any) (json.RawMessage, error) {
+	oldFields, err := fieldsOf(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := fieldsOf(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]AuditChange{}
+	for k, v := range newFields {
+		if old, ok := oldFields[k]; !ok || !reflect.DeepEqual(old, v) {
+			changes[k] = AuditChange{Old: oldFields[k], New: v}
+		}
+	}
+	for k, v := range oldFields {
+		if _, ok := newFields[k]; !ok {
+			changes[k] = AuditChange{Old: v}
+		}
+	}
+	return json.Marshal(changes)
+}
+
+func fieldsOf(v any) (map[string]any, error) {
+	fields := map[string]any{}
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return fields, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
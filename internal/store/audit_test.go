@@ -0,0 +1,51 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	before := &Post{ID: 1, Title: "title", Content: "old"}
+	after := &Post{ID: 1, Title: "title", Content: "new"}
+
+	raw, err := Diff(before, after)
+	if err != nil {
+		t.Fatalf("Diff returned error: %v", err)
+	}
+	var changes map[string]AuditChange
+	if err := json.Unmarshal(raw, &changes); err != nil {
+		t.Fatalf("Failed to decode diff: %v", err)
+	}
+	if len(changes) != 1 {
+		t.Errorf("Expected 1 change, got %v", changes)
+	}
+	if changes["content"].Old != "old" || changes["content"].New != "new" {
+		t.Errorf("Expected content old -> new, got %v", changes["content"])
+	}
+}
+
+func TestDiffCreateAndDelete(t *testing.T) {
+	post := &Post{ID: 1, Title: "title"}
+
+	raw, err := Diff(nil, post)
+	if err != nil {
+		t.Fatalf("Diff returned error: %v", err)
+	}
+	var created map[string]AuditChange
+	_ = json.Unmarshal(raw, &created)
+	if created["title"].New != "title" || created["title"].Old != nil {
+		t.Errorf("Expected title to be created, got %v", created["title"])
+	}
+
+	var nilPost *Post
+	raw, err = Diff(post, nilPost)
+	if err != nil {
+		t.Fatalf("Diff returned error: %v", err)
+	}
+	var deleted map[string]AuditChange
+	_ = json.Unmarshal(raw, &deleted)
+	if deleted["title"].Old != "title" || deleted["title"].New != nil {
+		t.Errorf("Expected title to be deleted, got %v", deleted["title"])
+	}
+}
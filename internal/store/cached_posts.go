@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"devops/internal/cache"
+	"devops/internal/metrics"
+	"encoding/json"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+	"strconv"
+	"time"
+)
+
+const postsListCacheKey = "posts:list"
+
+func postCacheKey(id int64) string {
+	return "posts:" + strconv.FormatInt(id, 10)
+}
+
+// CachedPosts is a read-through cache in front of another Posts. Reads of a
+// single post and of the feed are cached for ttl; every write invalidates the
+// entries it affects. Concurrent misses for the same key share one query.
+//
+// A read that started before an invalidation can still store the old value
+// once it completes, so entries may be stale for at most ttl.
+type CachedPosts struct {
+	Posts
+	cache cache.Cache
+	ttl   time.Duration
+	group singleflight.Group
+}
+
+func NewCachedPosts(next Posts, c cache.Cache, ttl time.Duration) *CachedPosts {
+	return &CachedPosts{Posts: next, cache: c, ttl: ttl}
+}
+
+func (s *CachedPosts) GetByID(ctx context.Context, postID int64) (*Post, error) {
+	var post *Post
+	err := s.read(ctx, postCacheKey(postID), &post, func(ctx context.Context) (any, error) {
+		return s.Posts.GetByID(ctx, postID)
+	})
+	return post, err
+}
+
+func (s *CachedPosts) GetList(ctx context.Context) ([]*Post, error) {
+	var posts []*Post
+	err := s.read(ctx, postsListCacheKey, &posts, func(ctx context.Context) (any, error) {
+		return s.Posts.GetList(ctx)
+	})
+	return posts, err
+}
+
+func (s *CachedPosts) Create(ctx context.Context, post *Post, also ...TxFunc) error {
+	if err := s.Posts.Create(ctx, post, also...); err != nil {
+		return err
+	}
+	s.invalidate(ctx, postsListCacheKey)
+	return nil
+}
+
+func (s *CachedPosts) Edit(ctx context.Context, post *Post, also ...TxFunc) error {
+	if err := s.Posts.Edit(ctx, post, also...); err != nil {
+		return err
+	}
+	s.invalidate(ctx, postCacheKey(post.ID), postsListCacheKey)
+	return nil
+}
+
+func (s *CachedPosts) Delete(ctx context.Context, postID int64, also ...TxFunc) error {
+	if err := s.Posts.Delete(ctx, postID, also...); err != nil {
+		return err
+	}
+	s.invalidate(ctx, postCacheKey(postID), postsListCacheKey)
+	return nil
+}
+
+func (s *CachedPosts) SetHidden(ctx context.Context, postID int64, hidden bool, also ...TxFunc) error {
+	if err := s.Posts.SetHidden(ctx, postID, hidden, also...); err != nil {
+		return err
+	}
+	s.invalidate(ctx, postCacheKey(postID), postsListCacheKey)
+	return nil
+}
+
+// read decodes the cached value for key into dst, loading and caching it on a
+// miss. Values are passed around encoded so every caller decodes its own copy
+// and may modify it freely.
+func (s *CachedPosts) read(ctx context.Context, key string, dst any, load func(context.Context) (any, error)) error {
+	data, ok, err := s.cache.Get(ctx, key)
+	switch {
+	case err != nil:
+		metrics.CacheRequests.WithLabelValues("posts", "error").Inc()
+		trace.SpanFromContext(ctx).RecordError(err)
+	case ok && json.Unmarshal(data, dst) == nil:
+		metrics.CacheRequests.WithLabelValues("posts", "hit").Inc()
+		return nil
+	default:
+		metrics.CacheRequests.WithLabelValues("posts", "miss").Inc()
+	}
+
+	v, err, _ := s.group.Do(key, func() (any, error) {
+		// The query is shared, so one caller giving up must not cancel it
+		// for the others.
+		ctx := context.WithoutCancel(ctx)
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.cache.Set(ctx, key, data, s.ttl); err != nil {
+			trace.SpanFromContext(ctx).RecordError(err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(v.([]byte), dst)
+}
+
+// invalidate drops keys after a write. The write has already been committed,
+// so a cache failure is recorded rather than returned; the entries then
+// expire with their ttl.
+func (s *CachedPosts) invalidate(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		s.group.Forget(key)
+	}
+	if err := s.cache.Delete(ctx, keys...); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"devops/internal/cache"
+	"testing"
+	"time"
+)
+
+type countingPosts struct {
+	Posts
+	posts map[int64]*Post
+	reads int
+}
+
+func (p *countingPosts) GetByID(_ context.Context, id int64) (*Post, error) {
+	p.reads++
+	post, ok := p.posts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	clone := *post
+	return &clone, nil
+}
+
+func (p *countingPosts) Edit(_ context.Context, post *Post, _ ...TxFunc) error {
+	clone := *post
+	p.posts[post.ID] = &clone
+	return nil
+}
+
+func TestCachedPostsReadThroughAndInvalidate(t *testing.T) {
+	ctx := context.Background()
+	next := &countingPosts{posts: map[int64]*Post{1: {ID: 1, Content: "first"}}}
+	posts := NewCachedPosts(next, cache.NewLRU(10), time.Minute)
+
+	post, err := posts.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetByID returned error: %v", err)
+	}
+	post.Content = "modified by caller"
+
+	post, _ = posts.GetByID(ctx, 1)
+	if next.reads != 1 {
+		t.Errorf("Expected second read to be served from cache, got %d reads", next.reads)
+	}
+	if post.Content != "first" {
+		t.Errorf("Expected cached copy to be unaffected by callers, got %q", post.Content)
+	}
+
+	post.Content = "second"
+	if err := posts.Edit(ctx, post); err != nil {
+		t.Fatalf("Edit returned error: %v", err)
+	}
+	post, _ = posts.GetByID(ctx, 1)
+	if next.reads != 2 || post.Content != "second" {
+		t.Errorf("Expected edit to invalidate the entry, got %d reads and %q", next.reads, post.Content)
+	}
+
+	if _, err := posts.GetByID(ctx, 2); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
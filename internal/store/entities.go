@@ -0,0 +1,55 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+)
+
+// Entities are the structured parts of a post's content, extracted when the
+// post is written. Offsets index Content in UTF-16 code units, as JavaScript
+// strings do, and span the leading @ or #; End is exclusive.
+type Entities struct {
+	Mentions []Mention `json:"mentions"`
+	Hashtags []Hashtag `json:"hashtags"`
+}
+
+// Mention is an @username that resolved to exactly one user.
+type Mention struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+// Hashtag is a #tag. Tag is lower-cased and has no leading #.
+type Hashtag struct {
+	Tag   string `json:"tag"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Value stores entities as JSONB, with empty lists rather than null.
+func (e Entities) Value() (driver.Value, error) {
+	if e.Mentions == nil {
+		e.Mentions = []Mention{}
+	}
+	if e.Hashtags == nil {
+		e.Hashtags = []Hashtag{}
+	}
+	return json.Marshal(e)
+}
+
+func (e *Entities) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*e = Entities{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	default:
+		return errors.New("store: unsupported entities type")
+	}
+}
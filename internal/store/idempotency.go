@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key. Status is zero while the first request is in progress.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type IdempotencyStore struct {
+	db *sql.DB
+}
+
+// Acquire claims key within scope for a request with the given fingerprint.
+// It succeeds when the key is new, has expired, or is held by a request whose
+// lock has lapsed. Otherwise it returns the existing record and false.
+func (s *IdempotencyStore) Acquire(ctx context.Context, scope, key, fingerprint string, lock, ttl time.Duration) (_ *IdempotencyRecord, acquired bool, err error) {
+	ctx, q := startQuery(ctx, "idempotency", "Acquire")
+	defer q.end(&err)
+
+	query := `
+	INSERT INTO idempotency_keys (scope, key, fingerprint, locked_until, expires_at)
+	VALUES ($1, $2, $3, now() + make_interval(secs => $4), now() + make_interval(secs => $5))
+	ON CONFLICT (scope, key) DO UPDATE SET
+		fingerprint = EXCLUDED.fingerprint,
+		response_status = NULL,
+		response_content_type = NULL,
+		response_body = NULL,
+		locked_until = EXCLUDED.locked_until,
+		expires_at = EXCLUDED.expires_at,
+		created_at = now()
+	WHERE idempotency_keys.expires_at < now()
+		OR (idempotency_keys.response_status IS NULL AND idempotency_keys.locked_until < now())
+	RETURNING key;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var claimed string
+	err = s.db.QueryRowContext(ctx, query, scope, key, fingerprint, lock.Seconds(), ttl.Seconds()).Scan(&claimed)
+	switch {
+	case err == nil:
+		q.setRows(1)
+		return &IdempotencyRecord{Fingerprint: fingerprint}, true, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, false, err
+	}
+
+	query = `
+	SELECT fingerprint, COALESCE(response_status, 0), COALESCE(response_content_type, ''), response_body
+	FROM idempotency_keys
+	WHERE scope = $1 AND key = $2;
+	`
+
+	var rec IdempotencyRecord
+	err = s.db.QueryRowContext(ctx, query, scope, key).Scan(
+		&rec.Fingerprint,
+		&rec.Status,
+		&rec.ContentType,
+		&rec.Body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, ErrNotFound
+		}
+		return nil, false, err
+	}
+	q.setRows(1)
+	return &rec, false, nil
+}
+
+// Complete stores the response for a key claimed with Acquire.
+func (s *IdempotencyStore) Complete(ctx context.Context, scope, key string, status int, contentType string, body []byte) (err error) {
+	ctx, q := startQuery(ctx, "idempotency", "Complete")
+	defer q.end(&err)
+
+	query := `
+	UPDATE idempotency_keys
+	SET response_status = $3, response_content_type = $4, response_body = $5
+	WHERE scope = $1 AND key = $2;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, scope, key, status, contentType, body)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	q.setRows(rows)
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Release drops an in-progress key so that the client can retry, used when
+// the request failed without a result worth replaying.
+func (s *IdempotencyStore) Release(ctx context.Context, scope, key string) (err error) {
+	ctx, q := startQuery(ctx, "idempotency", "Release")
+	defer q.end(&err)
+
+	query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND response_status IS NULL;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, scope, key)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	q.setRows(rows)
+	return nil
+}
+
+// DeleteExpired removes keys whose TTL has passed.
+func (s *IdempotencyStore) DeleteExpired(ctx context.Context) (_ int64, err error) {
+	ctx, q := startQuery(ctx, "idempotency", "DeleteExpired")
+	defer q.end(&err)
+
+	query := `DELETE FROM idempotency_keys WHERE expires_at < now();`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	q.setRows(rows)
+	return rows, nil
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"devops/internal/metrics"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+var tracer = otel.Tracer("devops/internal/store")
+
+// queryTracker instruments a single store method call with a child span and
+// the per-method latency histogram.
+type queryTracker struct {
+	span   trace.Span
+	store  string
+	method string
+	start  time.Time
+	rows   int64
+}
+
+// startQuery must be paired with a deferred end:
+//
+//	ctx, q := startQuery(ctx, "posts", "GetByID")
+//	defer q.end(&err)
+func startQuery(ctx context.Context, store, method string) (context.Context, *queryTracker) {
+	ctx, span := tracer.Start(ctx, store+"."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement.name", store+"."+method),
+		),
+	)
+	return ctx, &queryTracker{span: span, store: store, method: method, start: time.Now()}
+}
+
+func (q *queryTracker) setRows(n int64) {
+	q.rows = n
+}
+
+func (q *queryTracker) end(errp *error) {
+	metrics.ObserveQuery(q.store, q.method, q.start)
+
+	q.span.SetAttributes(attribute.Int64("db.rows", q.rows))
+	if err := *errp; err != nil && !errors.Is(err, ErrNotFound) {
+		q.span.RecordError(err)
+		q.span.SetStatus(codes.Error, err.Error())
+	}
+	q.span.End()
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+const (
+	ModerationBanUser    = "ban_user"
+	ModerationUnbanUser  = "unban_user"
+	ModerationDeletePost = "delete_post"
+	ModerationHidePost   = "hide_post"
+	ModerationUnhidePost = "unhide_post"
+
+	TargetUser    = "user"
+	TargetPost    = "post"
+	TargetWebhook = "webhook"
+	TargetJob     = "job"
+)
+
+type ModerationAction struct {
+	ID         int64  `json:"id"`
+	ActorID    int64  `json:"actor_id"`
+	Action     string `json:"action"`
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+	Reason     string `json:"reason"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// Moderate returns a TxFunc that records action in the transaction of the
+// change it describes, so a decision is recorded if and only if it is applied.
+func Moderate(action *ModerationAction) TxFunc {
+	return func(ctx context.Context, tx *sql.Tx) (err error) {
+		ctx, q := startQuery(ctx, "moderation", "Create")
+		defer q.end(&err)
+
+		query := `
+		INSERT INTO moderation_actions (actor_id, action, target_type, target_id, reason)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+		`
+
+		err = tx.QueryRowContext(ctx, query,
+			action.ActorID, action.Action, action.TargetType, action.TargetID, action.Reason).Scan(
+			&action.ID,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		q.setRows(1)
+		return nil
+	}
+}
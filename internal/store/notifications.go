@@ -0,0 +1,357 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/lib/pq"
+	"slices"
+	"time"
+)
+
+// Email delivery modes.
+const (
+	EmailInstant = "instant"
+	EmailDigest  = "digest"
+	EmailOff     = "off"
+)
+
+// NotificationPreferences controls how a user is notified. Users without a
+// stored row get instant email for every kind.
+type NotificationPreferences struct {
+	UserID     int64     `json:"-"`
+	Email      string    `json:"email"`
+	MutedKinds []string  `json:"muted_kinds"`
+	UpdatedAt  time.Time `json:"updated_at,omitempty"`
+}
+
+// Muted reports whether notifications of kind are turned off.
+func (p *NotificationPreferences) Muted(kind string) bool {
+	return slices.Contains(p.MutedKinds, kind)
+}
+
+// DigestItem is a notification held back for the recipient's next digest.
+type DigestItem struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"user_id"`
+	Kind      string          `json:"kind"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Notification is an entry in a user's in-app inbox. Data holds the same
+// kind-specific fields as the email, e.g. post_id, post_title and actor.
+type Notification struct {
+	ID        int64           `json:"id"`
+	UserID    int64           `json:"-"`
+	Kind      string          `json:"kind"`
+	Data      json.RawMessage `json:"data" swaggertype:"object"`
+	ReadAt    *time.Time      `json:"read_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// InboxFilter selects a page of an inbox, newest first. Cursor is the id
+// of the last notification of the previous page.
+type InboxFilter struct {
+	UnreadOnly bool
+	Cursor     int64
+	Limit      int
+}
+
+// Execer is satisfied by *sql.DB and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// AddToInbox appends a notification to the user's inbox. Pass a *sql.Tx to
+// add it atomically with the change that caused it.
+func AddToInbox(ctx context.Context, db Execer, userID int64, kind string, data json.RawMessage) (err error) {
+	ctx, q := startQuery(ctx, "notifications", "AddToInbox")
+	defer q.end(&err)
+
+	query := `INSERT INTO notifications (user_id, kind, data) VALUES ($1, $2, $3);`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	if _, err = db.ExecContext(ctx, query, userID, kind, []byte(data)); err != nil {
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+type NotificationsStore struct {
+	db *sql.DB
+}
+
+func (s *NotificationsStore) GetPreferences(ctx context.Context, userID int64) (_ *NotificationPreferences, err error) {
+	ctx, q := startQuery(ctx, "notifications", "GetPreferences")
+	defer q.end(&err)
+
+	query := `
+	SELECT email, muted_kinds, updated_at
+	FROM notification_preferences
+	WHERE user_id = $1;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	prefs := &NotificationPreferences{UserID: userID, Email: EmailInstant, MutedKinds: []string{}}
+	err = s.db.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.Email,
+		pq.Array(&prefs.MutedKinds),
+		&prefs.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return prefs, nil
+	case err != nil:
+		return nil, err
+	}
+	q.setRows(1)
+	return prefs, nil
+}
+
+func (s *NotificationsStore) SetPreferences(ctx context.Context, prefs *NotificationPreferences) (err error) {
+	ctx, q := startQuery(ctx, "notifications", "SetPreferences")
+	defer q.end(&err)
+
+	query := `
+	INSERT INTO notification_preferences (user_id, email, muted_kinds)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id) DO UPDATE SET
+		email = EXCLUDED.email,
+		muted_kinds = EXCLUDED.muted_kinds,
+		updated_at = NOW()
+	RETURNING updated_at;
+	`
+
+	if prefs.MutedKinds == nil {
+		prefs.MutedKinds = []string{}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, query,
+		prefs.UserID, prefs.Email, pq.Array(prefs.MutedKinds)).Scan(&prefs.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+func (s *NotificationsStore) AddDigestItem(ctx context.Context, item *DigestItem) (err error) {
+	ctx, q := startQuery(ctx, "notifications", "AddDigestItem")
+	defer q.end(&err)
+
+	query := `
+	INSERT INTO notification_digest_items (user_id, kind, data)
+	VALUES ($1, $2, $3) RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, query, item.UserID, item.Kind, []byte(item.Data)).Scan(
+		&item.ID,
+		&item.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+// DigestRecipients returns the users with notifications waiting for a digest.
+func (s *NotificationsStore) DigestRecipients(ctx context.Context) (_ []int64, err error) {
+	ctx, q := startQuery(ctx, "notifications", "DigestRecipients")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT user_id FROM notification_digest_items ORDER BY user_id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	q.setRows(int64(len(ids)))
+	return ids, rows.Err()
+}
+
+// DrainDigest passes the user's waiting notifications, oldest first, to fn
+// and deletes them once fn succeeds. Items locked by a concurrent drain are
+// skipped, and nothing is deleted when fn fails. fn is not called when there
+// is nothing to send.
+func (s *NotificationsStore) DrainDigest(ctx context.Context, userID int64, fn func([]*DigestItem) error) (err error) {
+	ctx, q := startQuery(ctx, "notifications", "DrainDigest")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, user_id, kind, data, created_at
+	FROM notification_digest_items
+	WHERE user_id = $1
+	ORDER BY id
+	FOR UPDATE SKIP LOCKED;
+	`
+
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+		var items []*DigestItem
+		ids := []int64{}
+		for rows.Next() {
+			item := &DigestItem{}
+			var data []byte
+			if err := rows.Scan(&item.ID, &item.UserID, &item.Kind, &data, &item.CreatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			item.Data = data
+			items = append(items, item)
+			ids = append(ids, item.ID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		q.setRows(int64(len(items)))
+
+		if err := fn(items); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM notification_digest_items WHERE id = ANY($1);`, pq.Array(ids))
+		return err
+	})
+}
+
+func (s *NotificationsStore) ListInbox(ctx context.Context, userID int64, filter InboxFilter) (_ []*Notification, err error) {
+	ctx, q := startQuery(ctx, "notifications", "ListInbox")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, user_id, kind, data, read_at, created_at
+	FROM notifications
+	WHERE user_id = $1
+		AND (NOT $2 OR read_at IS NULL)
+		AND ($3 = 0 OR id < $3)
+	ORDER BY id DESC
+	LIMIT $4;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, filter.UnreadOnly, filter.Cursor, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*Notification{}
+	for rows.Next() {
+		n := &Notification{}
+		var data []byte
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &data, &n.ReadAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		n.Data = data
+		notifications = append(notifications, n)
+	}
+	q.setRows(int64(len(notifications)))
+	return notifications, rows.Err()
+}
+
+func (s *NotificationsStore) UnreadCount(ctx context.Context, userID int64) (_ int64, err error) {
+	ctx, q := startQuery(ctx, "notifications", "UnreadCount")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var count int64
+	err = s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL;`, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	q.setRows(1)
+	return count, nil
+}
+
+// MarkRead marks one of the user's notifications as read. Marking a read
+// notification again keeps its original read_at.
+func (s *NotificationsStore) MarkRead(ctx context.Context, userID, id int64) (err error) {
+	ctx, q := startQuery(ctx, "notifications", "MarkRead")
+	defer q.end(&err)
+
+	query := `
+	UPDATE notifications
+	SET read_at = COALESCE(read_at, NOW())
+	WHERE id = $1 AND user_id = $2;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	q.setRows(n)
+	return nil
+}
+
+// MarkAllRead marks the user's unread notifications as read, up to and
+// including id upTo when it is non-zero, so that notifications arriving after
+// the client rendered its inbox stay unread. It returns how many changed.
+func (s *NotificationsStore) MarkAllRead(ctx context.Context, userID, upTo int64) (_ int64, err error) {
+	ctx, q := startQuery(ctx, "notifications", "MarkAllRead")
+	defer q.end(&err)
+
+	query := `
+	UPDATE notifications
+	SET read_at = NOW()
+	WHERE user_id = $1 AND read_at IS NULL AND ($2 = 0 OR id <= $2);
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, upTo)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	q.setRows(n)
+	return n, nil
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Outbox topics. They are the event names webhook subscribers filter on.
+const (
+	TopicPostCreated  = "post.created"
+	TopicPostEdited   = "post.edited"
+	TopicPostDeleted  = "post.deleted"
+	TopicPostHidden   = "post.hidden"
+	TopicPostUnhidden = "post.unhidden"
+)
+
+// Topics lists every outbox topic.
+var Topics = []string{TopicPostCreated, TopicPostEdited, TopicPostDeleted, TopicPostHidden, TopicPostUnhidden}
+
+// TxFunc runs inside the transaction of a write, after the change itself.
+// Writes accept them so that records describing a change, such as a
+// moderation decision, commit or roll back together with it.
+type TxFunc func(ctx context.Context, tx *sql.Tx) error
+
+// runTxFuncs runs fns in order, stopping at the first error.
+func runTxFuncs(ctx context.Context, tx *sql.Tx, fns []TxFunc) error {
+	for _, fn := range fns {
+		if err := fn(ctx, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withTx runs fn in a transaction, committing when it returns nil.
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeOutbox records an event in the same transaction as the change it
+// describes, so the event is published if and only if the change commits.
+func writeOutbox(ctx context.Context, tx *sql.Tx, topic string, aggregateID int64, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO outbox (topic, aggregate_id, payload) VALUES ($1, $2, $3);`,
+		topic, aggregateID, data)
+	return err
+}
+
+type OutboxStore struct {
+	db *sql.DB
+}
+
+// FanOut turns up to limit undispatched outbox events into one pending
+// delivery per matching active webhook subscription and marks the events as
+// dispatched, all in one statement. Concurrent callers skip each other's rows.
+func (s *OutboxStore) FanOut(ctx context.Context, limit int) (_ int64, err error) {
+	ctx, q := startQuery(ctx, "outbox", "FanOut")
+	defer q.end(&err)
+
+	query := `
+	WITH batch AS (
+		SELECT id, topic, payload
+		FROM outbox
+		WHERE dispatched_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	), fanout AS (
+		INSERT INTO webhook_deliveries (subscription_id, outbox_id, event, payload)
+		SELECT s.id, b.id, b.topic, b.payload
+		FROM batch b
+		JOIN webhook_subscriptions s
+			ON s.active AND (cardinality(s.events) = 0 OR b.topic = ANY(s.events))
+	)
+	UPDATE outbox SET dispatched_at = NOW()
+	FROM batch
+	WHERE outbox.id = batch.id;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	q.setRows(rows)
+	return rows, nil
+}
+
+// DeleteDispatchedBefore removes events that were dispatched before t.
+func (s *OutboxStore) DeleteDispatchedBefore(ctx context.Context, t time.Time) (_ int64, err error) {
+	ctx, q := startQuery(ctx, "outbox", "DeleteDispatchedBefore")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM outbox WHERE dispatched_at < $1;`, t)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	q.setRows(rows)
+	return rows, nil
+}
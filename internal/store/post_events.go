@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	PostEventCreated = "created"
+	PostEventEdited  = "edited"
+	PostEventDeleted = "deleted"
+)
+
+// PostEvent is a change to the public feed, written by the posts trigger.
+// Post is the post after the change and is empty for deletions.
+type PostEvent struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	PostID    int64           `json:"post_id"`
+	Post      json.RawMessage `json:"post,omitempty" swaggertype:"object"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type PostEventsStore struct {
+	db *sql.DB
+}
+
+// ListSince returns up to limit events with an id greater than afterID,
+// oldest first.
+func (s *PostEventsStore) ListSince(ctx context.Context, afterID int64, limit int) (_ []*PostEvent, err error) {
+	ctx, q := startQuery(ctx, "post_events", "ListSince")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, type, post_id, post, created_at
+	FROM post_events
+	WHERE id > $1
+	ORDER BY id
+	LIMIT $2;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*PostEvent
+	for rows.Next() {
+		event := &PostEvent{}
+		var post []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.PostID, &post, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if post != nil {
+			event.Post = post
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	q.setRows(int64(len(events)))
+	return events, nil
+}
+
+// Bounds returns the ids of the oldest and newest retained events, both zero
+// when there are none.
+func (s *PostEventsStore) Bounds(ctx context.Context) (oldest, newest int64, err error) {
+	ctx, q := startQuery(ctx, "post_events", "Bounds")
+	defer q.end(&err)
+
+	query := `SELECT COALESCE(MIN(id), 0), COALESCE(MAX(id), 0) FROM post_events;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, query).Scan(&oldest, &newest)
+	if err != nil {
+		return 0, 0, err
+	}
+	q.setRows(1)
+	return oldest, newest, nil
+}
+
+// DeleteBefore drops events older than t. Clients resuming from an event
+// that has been dropped have to reload the feed.
+func (s *PostEventsStore) DeleteBefore(ctx context.Context, t time.Time) (_ int64, err error) {
+	ctx, q := startQuery(ctx, "post_events", "DeleteBefore")
+	defer q.end(&err)
+
+	query := `DELETE FROM post_events WHERE created_at < $1;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, t)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	q.setRows(rows)
+	return rows, nil
+}
@@ -4,45 +4,71 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
+// Post is a user post. Format says how Content is written, plain or
+// markdown; ContentHTML is Content rendered and sanitised when the post was
+// written, so reads never render.
 type Post struct {
-	ID          int64  `json:"id"`
-	Content     string `json:"content"`
-	Title       string `json:"title"`
-	AuthorEmail string `json:"author_email"`
-	CreatedAt   string `json:"created_at"`
-	PhotoURL    string `json:"photo_url"`
+	ID          int64     `json:"id"`
+	Content     string    `json:"content"`
+	Title       string    `json:"title"`
+	AuthorEmail string    `json:"author_email"`
+	CreatedAt   string    `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	PhotoURL    string    `json:"photo_url"`
+	Hidden      bool      `json:"hidden"`
+	Entities    Entities  `json:"entities"`
+	Format      string    `json:"format"`
+	ContentHTML string    `json:"content_html"`
 }
 type PostsStore struct {
 	db *sql.DB
 }
 
-func (s *PostsStore) Create(ctx context.Context, post *Post) error {
+func (s *PostsStore) Create(ctx context.Context, post *Post, also ...TxFunc) (err error) {
+	ctx, q := startQuery(ctx, "posts", "Create")
+	defer q.end(&err)
+
 	query := `
-	INSERT INTO posts (content, title, author_email)
-	VALUES ($1, $2, $3) RETURNING id, created_at, content, author_email
+	INSERT INTO posts (content, title, author_email, entities, format, content_html)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at, content, author_email
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query,
-		post.Content, post.Title, post.AuthorEmail).Scan(
-		&post.ID,
-		&post.CreatedAt,
-		&post.Content,
-		&post.AuthorEmail,
-	)
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query,
+			post.Content, post.Title, post.AuthorEmail, post.Entities, post.Format, post.ContentHTML).Scan(
+			&post.ID,
+			&post.CreatedAt,
+			&post.UpdatedAt,
+			&post.Content,
+			&post.AuthorEmail,
+		)
+		if err != nil {
+			return err
+		}
+		if err := writeOutbox(ctx, tx, TopicPostCreated, post.ID, post); err != nil {
+			return err
+		}
+		return runTxFuncs(ctx, tx, also)
+	})
 	if err != nil {
 		return err
 	}
+	q.setRows(1)
 	return nil
 }
 
-func (s *PostsStore) GetByID(ctx context.Context, postId int64) (*Post, error) {
+func (s *PostsStore) GetByID(ctx context.Context, postId int64) (_ *Post, err error) {
+	ctx, q := startQuery(ctx, "posts", "GetByID")
+	defer q.end(&err)
+
 	query := `
-	SELECT id, author_email, title, content, created_at 
+	SELECT id, author_email, title, content, created_at, updated_at, image, hidden, entities, format, content_html
 	FROM posts 
 	WHERE ID =  $1;
 	`
@@ -50,68 +76,85 @@ func (s *PostsStore) GetByID(ctx context.Context, postId int64) (*Post, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
 
-	var post Post
-	err := s.db.QueryRowContext(ctx, query, postId).Scan(
-		&post.ID,
-		&post.AuthorEmail,
-		&post.Title,
-		&post.Content,
-		&post.CreatedAt)
+	post, err := scanPost(s.db.QueryRowContext(ctx, query, postId))
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrNotFound
-		default:
-			return nil, err
-		}
-
+		return nil, err
 	}
-	return &post, nil
+	q.setRows(1)
+	return post, nil
 }
 
-func (s *PostsStore) Delete(ctx context.Context, postID int64) error {
-	query := `DELETE FROM posts WHERE ID =  $1;`
+func (s *PostsStore) Delete(ctx context.Context, postID int64, also ...TxFunc) (err error) {
+	ctx, q := startQuery(ctx, "posts", "Delete")
+	defer q.end(&err)
+
+	query := `
+	DELETE FROM posts WHERE ID = $1
+	RETURNING id, author_email, title, content, created_at, updated_at, image, hidden, entities, format, content_html;
+	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
 	defer cancel()
 
-	res, err := s.db.ExecContext(ctx, query, postID)
-	if err != nil {
-		return err
-	}
-	rows, err := res.RowsAffected()
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		post, err := scanPost(tx.QueryRowContext(ctx, query, postID))
+		if err != nil {
+			return err
+		}
+		if err := writeOutbox(ctx, tx, TopicPostDeleted, post.ID, post); err != nil {
+			return err
+		}
+		return runTxFuncs(ctx, tx, also)
+	})
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
-		return ErrNotFound
-	}
+	q.setRows(1)
 	return nil
 }
 
-func (s *PostsStore) Edit(ctx context.Context, post *Post) error {
+func (s *PostsStore) Edit(ctx context.Context, post *Post, also ...TxFunc) (err error) {
+	ctx, q := startQuery(ctx, "posts", "Edit")
+	defer q.end(&err)
+
 	query := `UPDATE posts SET
-content = $1, image = $2
-WHERE ID = $3
-RETURNING id;`
+content = $1, image = $2, entities = $3, format = $4, content_html = $5
+WHERE ID = $6
+RETURNING id, updated_at;`
 
-	err := s.db.QueryRowContext(ctx,
-		query, post.Content, post.PhotoURL, post.ID).Scan(&post.ID)
-	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrNotFound
-		default:
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			query, post.Content, post.PhotoURL, post.Entities, post.Format, post.ContentHTML, post.ID).Scan(&post.ID, &post.UpdatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if err := writeOutbox(ctx, tx, TopicPostEdited, post.ID, post); err != nil {
 			return err
 		}
+		return runTxFuncs(ctx, tx, also)
+	})
+	if err != nil {
+		return err
 	}
+	q.setRows(1)
 	return nil
 }
 
-func (s *PostsStore) GetList(ctx context.Context) ([]*Post, error) {
+func (s *PostsStore) GetList(ctx context.Context) (_ []*Post, err error) {
+	ctx, q := startQuery(ctx, "posts", "GetList")
+	defer q.end(&err)
+
 	query := `
-	SELECT id, author_email, title, content, created_at, image
-	FROM posts;
+	SELECT id, author_email, title, content, created_at, updated_at, image, entities, format, content_html
+	FROM posts
+	WHERE hidden = false
+	ORDER BY id;
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
@@ -132,11 +175,76 @@ func (s *PostsStore) GetList(ctx context.Context) ([]*Post, error) {
 			&post.Title,
 			&post.Content,
 			&post.CreatedAt,
-			&post.PhotoURL)
+			&post.UpdatedAt,
+			&post.PhotoURL,
+			&post.Entities,
+			&post.Format,
+			&post.ContentHTML)
 		if err != nil {
 			return nil, err
 		}
 		posts = append(posts, post)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	q.setRows(int64(len(posts)))
 	return posts, nil
 }
+
+func (s *PostsStore) SetHidden(ctx context.Context, postID int64, hidden bool, also ...TxFunc) (err error) {
+	ctx, q := startQuery(ctx, "posts", "SetHidden")
+	defer q.end(&err)
+
+	query := `
+	UPDATE posts SET hidden = $1 WHERE ID = $2
+	RETURNING id, author_email, title, content, created_at, updated_at, image, hidden, entities, format, content_html;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	topic := TopicPostHidden
+	if !hidden {
+		topic = TopicPostUnhidden
+	}
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		post, err := scanPost(tx.QueryRowContext(ctx, query, hidden, postID))
+		if err != nil {
+			return err
+		}
+		if err := writeOutbox(ctx, tx, topic, post.ID, post); err != nil {
+			return err
+		}
+		return runTxFuncs(ctx, tx, also)
+	})
+	if err != nil {
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+// scanPost scans a full posts row, mapping no rows to ErrNotFound.
+func scanPost(row *sql.Row) (*Post, error) {
+	var post Post
+	err := row.Scan(
+		&post.ID,
+		&post.AuthorEmail,
+		&post.Title,
+		&post.Content,
+		&post.CreatedAt,
+		&post.UpdatedAt,
+		&post.PhotoURL,
+		&post.Hidden,
+		&post.Entities,
+		&post.Format,
+		&post.ContentHTML)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &post, nil
+}
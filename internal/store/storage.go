@@ -16,24 +16,80 @@ var (
 	MissionCompleted  = errors.New("missiion completed")
 )
 
+// Posts is named so that it can be wrapped, see NewCachedPosts.
+type Posts interface {
+	Create(ctx context.Context, post *Post, also ...TxFunc) error
+	GetByID(ctx context.Context, postId int64) (*Post, error)
+	Delete(ctx context.Context, postID int64, also ...TxFunc) error
+	Edit(ctx context.Context, post *Post, also ...TxFunc) error
+	GetList(ctx context.Context) ([]*Post, error)
+	SetHidden(ctx context.Context, postID int64, hidden bool, also ...TxFunc) error
+}
+
 type Storage struct {
 	Users interface {
-		CreateUser(username, email string) (*User, error) 
-		GetUserByID(id int64) (*User, error)
-		GetUserByEmail(email string) (*User, error)
+		CreateUser(ctx context.Context, username, email string) (*User, error)
+		GetUserByID(ctx context.Context, id int64) (*User, error)
+		GetUserByEmail(ctx context.Context, email string) (*User, error)
+		GetUsersByUsernames(ctx context.Context, usernames []string) ([]*User, error)
+		List(ctx context.Context) ([]*User, error)
+		SetBanned(ctx context.Context, id int64, banned bool, also ...TxFunc) error
+	}
+	Posts Posts
+	Audit interface {
+		Create(ctx context.Context, event *AuditEvent) error
+		List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+		Export(ctx context.Context, filter AuditFilter, fn func(*AuditEvent) error) error
+	}
+	PostEvents interface {
+		ListSince(ctx context.Context, afterID int64, limit int) ([]*PostEvent, error)
+		Bounds(ctx context.Context) (oldest, newest int64, err error)
+		DeleteBefore(ctx context.Context, t time.Time) (int64, error)
+	}
+	Outbox interface {
+		FanOut(ctx context.Context, limit int) (int64, error)
+		DeleteDispatchedBefore(ctx context.Context, t time.Time) (int64, error)
+	}
+	Webhooks interface {
+		Create(ctx context.Context, sub *WebhookSubscription) error
+		List(ctx context.Context) ([]*WebhookSubscription, error)
+		GetByID(ctx context.Context, id int64) (*WebhookSubscription, error)
+		Update(ctx context.Context, sub *WebhookSubscription) error
+		Delete(ctx context.Context, id int64) error
+		ListDeliveries(ctx context.Context, subscriptionID int64, filter DeliveryFilter) ([]*WebhookDelivery, error)
+		ListAttempts(ctx context.Context, subscriptionID, deliveryID int64) ([]*WebhookAttempt, error)
+		ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*DueDelivery, error)
+		RecordAttempt(ctx context.Context, attempt *WebhookAttempt, status string, nextAttemptAt time.Time) error
+		Redeliver(ctx context.Context, subscriptionID, deliveryID int64) error
+	}
+	Notifications interface {
+		GetPreferences(ctx context.Context, userID int64) (*NotificationPreferences, error)
+		SetPreferences(ctx context.Context, prefs *NotificationPreferences) error
+		AddDigestItem(ctx context.Context, item *DigestItem) error
+		DigestRecipients(ctx context.Context) ([]int64, error)
+		DrainDigest(ctx context.Context, userID int64, fn func([]*DigestItem) error) error
+		ListInbox(ctx context.Context, userID int64, filter InboxFilter) ([]*Notification, error)
+		UnreadCount(ctx context.Context, userID int64) (int64, error)
+		MarkRead(ctx context.Context, userID, id int64) error
+		MarkAllRead(ctx context.Context, userID, upTo int64) (int64, error)
 	}
-	Posts interface {
-		Create(ctx context.Context, post *Post) error
-		GetByID(ctx context.Context, postId int64) (*Post, error)
-		Delete(ctx context.Context, postID int64) error
-		Edit(ctx context.Context, post *Post) error
-		GetList(ctx context.Context) ([]*Post, error)
+	Idempotency interface {
+		Acquire(ctx context.Context, scope, key, fingerprint string, lock, ttl time.Duration) (*IdempotencyRecord, bool, error)
+		Complete(ctx context.Context, scope, key string, status int, contentType string, body []byte) error
+		Release(ctx context.Context, scope, key string) error
+		DeleteExpired(ctx context.Context) (int64, error)
 	}
 }
 
 func NewStorage(db *sql.DB) *Storage {
 	return &Storage{
-		Users: &UsersStore{db: db},
-		Posts: &PostsStore{db: db},
+		Users:         &UsersStore{db: db},
+		Posts:         &PostsStore{db: db},
+		Audit:         &AuditStore{db: db},
+		PostEvents:    &PostEventsStore{db: db},
+		Outbox:        &OutboxStore{db: db},
+		Webhooks:      &WebhooksStore{db: db},
+		Idempotency:   &IdempotencyStore{db: db},
+		Notifications: &NotificationsStore{db: db},
 	}
 }
@@ -1,45 +1,98 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"strings"
 	"time"
 )
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
 type User struct {
 	ID        int64     `json:"id"`
 	Username  string    `json:"username"`
 	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Banned    bool      `json:"banned"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// HasRole reports whether the user's role is at least the given one.
+func (u *User) HasRole(role string) bool {
+	return roleRank[u.Role] >= roleRank[role] && roleRank[role] > 0
+}
+
 type UsersStore struct {
 	db *sql.DB
 }
 
-func (store *UsersStore) GetUserByID(id int64) (*User, error) {
+func (store *UsersStore) GetUserByID(ctx context.Context, id int64) (_ *User, err error) {
+	ctx, q := startQuery(ctx, "users", "GetUserByID")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
 	user := &User{}
-	query := "SELECT id, username, email, created_at FROM users WHERE id = $1"
-	err := store.db.QueryRow(query, id).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+	query := "SELECT id, username, email, role, banned, created_at FROM users WHERE id = $1"
+	err = store.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.Banned, &user.CreatedAt)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
 	}
+	q.setRows(1)
 	return user, nil
 }
 
-func (store *UsersStore) GetUserByEmail(email string) (*User, error) {
+func (store *UsersStore) GetUserByEmail(ctx context.Context, email string) (_ *User, err error) {
+	ctx, q := startQuery(ctx, "users", "GetUserByEmail")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
 	user := &User{}
-	query := "SELECT id, username, email, created_at FROM users WHERE email = $1"
-	err := store.db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+	query := "SELECT id, username, email, role, banned, created_at FROM users WHERE email = $1"
+	err = store.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.Banned, &user.CreatedAt)
 	if err != nil {
-		return nil, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
 	}
+	q.setRows(1)
 	return user, nil
 }
 
-func (store *UsersStore) CreateUser(username, email string) (*User, error) {
+func (store *UsersStore) CreateUser(ctx context.Context, username, email string) (_ *User, err error) {
+	ctx, q := startQuery(ctx, "users", "CreateUser")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
 	user := &User{}
-	query := "INSERT INTO users (username, email) VALUES ($1, $2) RETURNING id, username, email, created_at"
-	err := store.db.QueryRow(query, username, email).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt)
+	query := "INSERT INTO users (username, email) VALUES ($1, $2) RETURNING id, username, email, role, banned, created_at"
+	err = store.db.QueryRowContext(ctx, query, username, email).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.Banned, &user.CreatedAt)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -48,5 +101,109 @@ func (store *UsersStore) CreateUser(username, email string) (*User, error) {
 			return nil, err
 		}
 	}
+	q.setRows(1)
 	return user, nil
 }
+
+func (store *UsersStore) List(ctx context.Context) (_ []*User, err error) {
+	ctx, q := startQuery(ctx, "users", "List")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, username, email, role, banned, created_at
+	FROM users
+	ORDER BY id;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user := &User{}
+		err = rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.Banned, &user.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	q.setRows(int64(len(users)))
+	return users, rows.Err()
+}
+
+// GetUsersByUsernames returns the users whose username matches one of
+// usernames, ignoring case. Usernames are not unique, so a name may match
+// several users.
+func (store *UsersStore) GetUsersByUsernames(ctx context.Context, usernames []string) (_ []*User, err error) {
+	ctx, q := startQuery(ctx, "users", "GetUsersByUsernames")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, username, email, role, banned, created_at
+	FROM users
+	WHERE lower(username) = ANY($1)
+	ORDER BY id;
+	`
+
+	lower := make([]string, len(usernames))
+	for i, name := range usernames {
+		lower[i] = strings.ToLower(name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, pq.Array(lower))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user := &User{}
+		err = rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.Banned, &user.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	q.setRows(int64(len(users)))
+	return users, rows.Err()
+}
+
+func (store *UsersStore) SetBanned(ctx context.Context, id int64, banned bool, also ...TxFunc) (err error) {
+	ctx, q := startQuery(ctx, "users", "SetBanned")
+	defer q.end(&err)
+
+	query := `UPDATE users SET banned = $1 WHERE id = $2;`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var rows int64
+	err = withTx(ctx, store.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, banned, id)
+		if err != nil {
+			return err
+		}
+		if rows, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotFound
+		}
+		return runTxFuncs(ctx, tx, also)
+	})
+	if err != nil {
+		return err
+	}
+	q.setRows(rows)
+	return nil
+}
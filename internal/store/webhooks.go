@@ -0,0 +1,446 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/lib/pq"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// WebhookSubscription delivers the outbox events named in Events, or every
+// event when Events is empty, to URL. Secret signs each delivery and is only
+// returned when the subscription is created.
+type WebhookSubscription struct {
+	ID          int64     `json:"id"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"secret,omitempty"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one outbox event on its way to one subscription.
+// EventID is the outbox id and is the same for every subscription and
+// attempt, so receivers can use it to deduplicate.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	SubscriptionID int64           `json:"subscription_id"`
+	EventID        int64           `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// WebhookAttempt is a delivery log entry.
+type WebhookAttempt struct {
+	ID             int64     `json:"id"`
+	DeliveryID     int64     `json:"delivery_id"`
+	Attempt        int       `json:"attempt"`
+	ResponseStatus *int      `json:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	DurationMS     int64     `json:"duration_ms"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// DueDelivery is a claimed delivery together with where to send it.
+type DueDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// DeliveryFilter narrows delivery queries. Cursor is the last seen delivery
+// ID and pages backwards from the newest delivery.
+type DeliveryFilter struct {
+	Status string
+	Cursor int64
+	Limit  int
+}
+
+type WebhooksStore struct {
+	db *sql.DB
+}
+
+func (s *WebhooksStore) Create(ctx context.Context, sub *WebhookSubscription) (err error) {
+	ctx, q := startQuery(ctx, "webhooks", "Create")
+	defer q.end(&err)
+
+	query := `
+	INSERT INTO webhook_subscriptions (url, secret, events, description, active)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, query,
+		sub.URL, sub.Secret, pq.Array(sub.Events), sub.Description, sub.Active).Scan(
+		&sub.ID,
+		&sub.CreatedAt,
+		&sub.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+func (s *WebhooksStore) List(ctx context.Context) (_ []*WebhookSubscription, err error) {
+	ctx, q := startQuery(ctx, "webhooks", "List")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, url, events, description, active, created_at, updated_at
+	FROM webhook_subscriptions
+	ORDER BY id;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*WebhookSubscription{}
+	for rows.Next() {
+		sub := &WebhookSubscription{}
+		err = rows.Scan(
+			&sub.ID,
+			&sub.URL,
+			pq.Array(&sub.Events),
+			&sub.Description,
+			&sub.Active,
+			&sub.CreatedAt,
+			&sub.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	q.setRows(int64(len(subs)))
+	return subs, rows.Err()
+}
+
+func (s *WebhooksStore) GetByID(ctx context.Context, id int64) (_ *WebhookSubscription, err error) {
+	ctx, q := startQuery(ctx, "webhooks", "GetByID")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, url, events, description, active, created_at, updated_at
+	FROM webhook_subscriptions
+	WHERE id = $1;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	var sub WebhookSubscription
+	err = s.db.QueryRowContext(ctx, query, id).Scan(
+		&sub.ID,
+		&sub.URL,
+		pq.Array(&sub.Events),
+		&sub.Description,
+		&sub.Active,
+		&sub.CreatedAt,
+		&sub.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	q.setRows(1)
+	return &sub, nil
+}
+
+// Update saves the URL, events, description and active flag of sub. The
+// secret is left unchanged unless sub.Secret is set.
+func (s *WebhooksStore) Update(ctx context.Context, sub *WebhookSubscription) (err error) {
+	ctx, q := startQuery(ctx, "webhooks", "Update")
+	defer q.end(&err)
+
+	query := `
+	UPDATE webhook_subscriptions SET
+		url = $1, events = $2, description = $3, active = $4,
+		secret = COALESCE(NULLIF($5, ''), secret), updated_at = NOW()
+	WHERE id = $6
+	RETURNING updated_at;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, query,
+		sub.URL, pq.Array(sub.Events), sub.Description, sub.Active, sub.Secret, sub.ID).Scan(&sub.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+// Delete removes a subscription together with its deliveries and log.
+func (s *WebhooksStore) Delete(ctx context.Context, id int64) (err error) {
+	ctx, q := startQuery(ctx, "webhooks", "Delete")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	q.setRows(rows)
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *WebhooksStore) ListDeliveries(ctx context.Context, subscriptionID int64, filter DeliveryFilter) (_ []*WebhookDelivery, err error) {
+	ctx, q := startQuery(ctx, "webhooks", "ListDeliveries")
+	defer q.end(&err)
+
+	query := `
+	SELECT id, subscription_id, outbox_id, event, payload, status, attempts,
+		next_attempt_at, response_status, last_error, created_at, delivered_at
+	FROM webhook_deliveries
+	WHERE subscription_id = $1
+		AND ($2 = '' OR status = $2)
+		AND ($3 = 0 OR id < $3)
+	ORDER BY id DESC
+	LIMIT $4;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, subscriptionID, filter.Status, filter.Cursor, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		var payload []byte
+		err = rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&d.EventID,
+			&d.Event,
+			&payload,
+			&d.Status,
+			&d.Attempts,
+			&d.NextAttemptAt,
+			&d.ResponseStatus,
+			&d.LastError,
+			&d.CreatedAt,
+			&d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		deliveries = append(deliveries, d)
+	}
+	q.setRows(int64(len(deliveries)))
+	return deliveries, rows.Err()
+}
+
+// ListAttempts returns the delivery log of one delivery, oldest first.
+func (s *WebhooksStore) ListAttempts(ctx context.Context, subscriptionID, deliveryID int64) (_ []*WebhookAttempt, err error) {
+	ctx, q := startQuery(ctx, "webhooks", "ListAttempts")
+	defer q.end(&err)
+
+	query := `
+	SELECT a.id, a.delivery_id, a.attempt, a.response_status, a.error, a.duration_ms, a.created_at
+	FROM webhook_delivery_attempts a
+	JOIN webhook_deliveries d ON d.id = a.delivery_id
+	WHERE d.subscription_id = $1 AND a.delivery_id = $2
+	ORDER BY a.id;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, subscriptionID, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*WebhookAttempt{}
+	for rows.Next() {
+		a := &WebhookAttempt{}
+		err = rows.Scan(
+			&a.ID,
+			&a.DeliveryID,
+			&a.Attempt,
+			&a.ResponseStatus,
+			&a.Error,
+			&a.DurationMS,
+			&a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	q.setRows(int64(len(attempts)))
+	return attempts, rows.Err()
+}
+
+// ClaimDue claims up to limit pending deliveries whose next attempt is due
+// and counts the attempt. The claim pushes next_attempt_at out by lease, so
+// a delivery whose worker dies is retried once the lease lapses.
+func (s *WebhooksStore) ClaimDue(ctx context.Context, limit int, lease time.Duration) (_ []*DueDelivery, err error) {
+	ctx, q := startQuery(ctx, "webhooks", "ClaimDue")
+	defer q.end(&err)
+
+	query := `
+	WITH due AS (
+		SELECT id
+		FROM webhook_deliveries
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE webhook_deliveries d SET
+		attempts = d.attempts + 1,
+		next_attempt_at = NOW() + make_interval(secs => $2)
+	FROM due, webhook_subscriptions s
+	WHERE d.id = due.id AND s.id = d.subscription_id
+	RETURNING d.id, d.subscription_id, d.outbox_id, d.event, d.payload, d.status,
+		d.attempts, d.created_at, s.url, s.secret;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*DueDelivery
+	for rows.Next() {
+		d := &DueDelivery{}
+		var payload []byte
+		err = rows.Scan(
+			&d.ID,
+			&d.SubscriptionID,
+			&d.EventID,
+			&d.Event,
+			&payload,
+			&d.Status,
+			&d.Attempts,
+			&d.CreatedAt,
+			&d.URL,
+			&d.Secret)
+		if err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		due = append(due, d)
+	}
+	q.setRows(int64(len(due)))
+	return due, rows.Err()
+}
+
+// RecordAttempt appends attempt to the delivery log and moves the delivery to
+// status. A pending delivery is retried at nextAttemptAt.
+func (s *WebhooksStore) RecordAttempt(ctx context.Context, attempt *WebhookAttempt, status string, nextAttemptAt time.Time) (err error) {
+	ctx, q := startQuery(ctx, "webhooks", "RecordAttempt")
+	defer q.end(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempt, response_status, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+		`, attempt.DeliveryID, attempt.Attempt, attempt.ResponseStatus, attempt.Error, attempt.DurationMS).Scan(
+			&attempt.ID,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET
+			status = $1,
+			next_attempt_at = $2,
+			response_status = $3,
+			last_error = $4,
+			delivered_at = CASE WHEN $1 = 'succeeded' THEN NOW() END
+		WHERE id = $5;
+		`, status, nextAttemptAt, attempt.ResponseStatus, attempt.Error, attempt.DeliveryID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	q.setRows(1)
+	return nil
+}
+
+// Redeliver queues a finished delivery, typically a dead-lettered one, to be
+// sent again with a fresh attempt budget.
+func (s *WebhooksStore) Redeliver(ctx context.Context, subscriptionID, deliveryID int64) (err error) {
+	ctx, q := startQuery(ctx, "webhooks", "Redeliver")
+	defer q.end(&err)
+
+	query := `
+	UPDATE webhook_deliveries SET
+		status = 'pending', attempts = 0, next_attempt_at = NOW(), delivered_at = NULL
+	WHERE id = $1 AND subscription_id = $2 AND status <> 'pending';
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOut)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, deliveryID, subscriptionID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	q.setRows(rows)
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"devops/internal/config"
+	"devops/internal/jobs"
+	"devops/internal/metrics"
+	"devops/internal/store"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// leaseMargin is added to the request timeout when claiming deliveries,
+	// so a slow attempt is not picked up again by another replica.
+	leaseMargin    = 30 * time.Second
+	maxErrorLength = 1000
+)
+
+type Store interface {
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*store.DueDelivery, error)
+	RecordAttempt(ctx context.Context, attempt *store.WebhookAttempt, status string, nextAttemptAt time.Time) error
+}
+
+type Outbox interface {
+	FanOut(ctx context.Context, limit int) (int64, error)
+}
+
+// Dispatcher moves outbox events into per-subscription deliveries and sends
+// them. Any number of replicas may run one; rows are claimed with SKIP LOCKED.
+type Dispatcher struct {
+	store  Store
+	outbox Outbox
+	cfg    config.Webhooks
+	client *http.Client
+	logger *zap.SugaredLogger
+	now    func() time.Time
+}
+
+func NewDispatcher(st Store, outbox Outbox, cfg config.Webhooks, logger *zap.SugaredLogger) *Dispatcher {
+	return &Dispatcher{
+		store:  st,
+		outbox: outbox,
+		cfg:    cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// A redirect is reported as a failure rather than followed, so
+			// a subscription cannot be bounced to an unintended host.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Run dispatches until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	poll := time.NewTicker(d.cfg.PollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			d.fanOut(ctx)
+			d.deliverDue(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) fanOut(ctx context.Context) {
+	for {
+		n, err := d.outbox.FanOut(ctx, d.cfg.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Errorw("failed to fan out outbox events", "error", err)
+			}
+			return
+		}
+		if n < int64(d.cfg.BatchSize) {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	due, err := d.store.ClaimDue(ctx, d.cfg.BatchSize, d.cfg.Timeout+leaseMargin)
+	if err != nil {
+		if ctx.Err() == nil {
+			d.logger.Errorw("failed to claim webhook deliveries", "error", err)
+		}
+		return
+	}
+
+	sem := make(chan struct{}, d.cfg.Workers)
+	var wg sync.WaitGroup
+	for _, delivery := range due {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			d.deliver(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver makes one attempt and records its outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *store.DueDelivery) {
+	start := d.now()
+	code, err := d.send(ctx, delivery)
+
+	attempt := &store.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		DurationMS: d.now().Sub(start).Milliseconds(),
+	}
+	if code != 0 {
+		attempt.ResponseStatus = &code
+	}
+
+	status, next := store.DeliverySucceeded, d.now()
+	if err != nil {
+		attempt.Error = err.Error()
+		if len(attempt.Error) > maxErrorLength {
+			attempt.Error = attempt.Error[:maxErrorLength]
+		}
+		status = store.DeliveryDead
+		if delivery.Attempts < d.cfg.MaxAttempts {
+			status = store.DeliveryPending
+			next = next.Add(jobs.Backoff(delivery.Attempts, d.cfg.BackoffBase, d.cfg.BackoffMax))
+		}
+		d.logger.Warnw("webhook delivery failed",
+			"delivery_id", delivery.ID, "subscription_id", delivery.SubscriptionID,
+			"attempt", delivery.Attempts, "status", status, "error", err)
+	}
+	metrics.WebhookDeliveries.WithLabelValues(status).Inc()
+
+	// Record the outcome even when shutdown interrupted the attempt; the
+	// lease would otherwise hold the delivery until it lapses.
+	if err := d.store.RecordAttempt(context.WithoutCancel(ctx), attempt, status, next); err != nil {
+		d.logger.Errorw("failed to record webhook attempt", "delivery_id", delivery.ID, "error", err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery *store.DueDelivery) (int, error) {
+	body, err := json.Marshal(Event{
+		ID:        delivery.EventID,
+		Type:      delivery.Event,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", strconv.FormatInt(delivery.EventID, 10))
+	req.Header.Set("Webhook-Event", delivery.Event)
+	req.Header.Set("Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("Webhook-Signature", Sign(delivery.Secret, delivery.EventID, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,101 @@
+package webhooks
+
+import (
+	"context"
+	"devops/internal/config"
+	"devops/internal/store"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type recordedAttempt struct {
+	attempt *store.WebhookAttempt
+	status  string
+	next    time.Time
+}
+
+type fakeStore struct {
+	recorded []recordedAttempt
+}
+
+func (f *fakeStore) ClaimDue(context.Context, int, time.Duration) ([]*store.DueDelivery, error) {
+	return nil, nil
+}
+
+func (f *fakeStore) RecordAttempt(_ context.Context, attempt *store.WebhookAttempt, status string, next time.Time) error {
+	f.recorded = append(f.recorded, recordedAttempt{attempt, status, next})
+	return nil
+}
+
+func TestDeliverSignsRequest(t *testing.T) {
+	var verified bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		id, _ := strconv.ParseInt(r.Header.Get("Webhook-Id"), 10, 64)
+		ts, _ := strconv.ParseInt(r.Header.Get("Webhook-Timestamp"), 10, 64)
+		verified = Verify("secret", id, ts, body, r.Header.Get("Webhook-Signature"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	st := &fakeStore{}
+	d := NewDispatcher(st, nil, config.Webhooks{Timeout: time.Second, MaxAttempts: 3}, zap.NewNop().Sugar())
+	d.deliver(context.Background(), &store.DueDelivery{
+		WebhookDelivery: store.WebhookDelivery{ID: 1, EventID: 42, Event: store.TopicPostCreated, Payload: []byte(`{"id":7}`), Attempts: 1},
+		URL:             srv.URL,
+		Secret:          "secret",
+	})
+
+	if !verified {
+		t.Error("Expected the receiver to verify the signature")
+	}
+	if len(st.recorded) != 1 || st.recorded[0].status != store.DeliverySucceeded {
+		t.Fatalf("Expected one succeeded attempt, got %+v", st.recorded)
+	}
+	if code := st.recorded[0].attempt.ResponseStatus; code == nil || *code != http.StatusNoContent {
+		t.Errorf("Expected response status 204, got %v", code)
+	}
+}
+
+func TestDeliverRetriesThenDeadLetters(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	st := &fakeStore{}
+	cfg := config.Webhooks{Timeout: time.Second, MaxAttempts: 2, BackoffBase: time.Minute, BackoffMax: time.Hour}
+	d := NewDispatcher(st, nil, cfg, zap.NewNop().Sugar())
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		d.deliver(context.Background(), &store.DueDelivery{
+			WebhookDelivery: store.WebhookDelivery{ID: 1, EventID: 42, Attempts: attempt},
+			URL:             srv.URL,
+			Secret:          "secret",
+		})
+	}
+
+	if len(st.recorded) != 2 {
+		t.Fatalf("Expected 2 recorded attempts, got %d", len(st.recorded))
+	}
+	first, last := st.recorded[0], st.recorded[1]
+	if first.status != store.DeliveryPending {
+		t.Errorf("Expected first failure to be retried, got %s", first.status)
+	}
+	if wait := first.next.Sub(now); wait < 30*time.Second || wait > time.Minute {
+		t.Errorf("Expected retry within backoff of 30s-1m, got %s", wait)
+	}
+	if first.attempt.Error == "" {
+		t.Error("Expected the failure to be logged")
+	}
+	if last.status != store.DeliveryDead {
+		t.Errorf("Expected delivery to be dead-lettered after max attempts, got %s", last.status)
+	}
+}
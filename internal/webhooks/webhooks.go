@@ -0,0 +1,58 @@
+// Package webhooks delivers outbox events to subscriber URLs.
+//
+// Each delivery is a POST of an Event as JSON with these headers:
+//
+//	Webhook-Id:        the event id, identical across retries
+//	Webhook-Event:     the event type, e.g. post.created
+//	Webhook-Timestamp: unix seconds when the attempt was made
+//	Webhook-Signature: v1=<hex HMAC-SHA256 of "id.timestamp.body" keyed by the secret>
+//
+// Receivers should check the signature with Verify, reject stale timestamps
+// and deduplicate on Webhook-Id, since delivery is at least once.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const signaturePrefix = "v1="
+
+// Event is the body of a webhook delivery.
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the Webhook-Signature header value for a delivery.
+func Sign(secret string, id, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(id, 10) + "." + strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of the delivery.
+func Verify(secret string, id, timestamp int64, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, id, timestamp, body)), []byte(signature))
+}
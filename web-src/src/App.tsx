@@ -1,13 +1,36 @@
 import React, { useEffect, useState } from "react";
-import { getPosts, createPost, updatePost, deletePost } from "./api";
-import type { Post } from "./types";
+import { getPosts, createPost, updatePost, deletePost, subscribePosts } from "./api";
+import type { Post, PostEvent, PostFormat } from "./types";
 
 const path = "http://localhost:3001/public";
 
+// renderContent highlights the mentions and hashtags the server extracted.
+const renderContent = (post: Post): React.ReactNode[] => {
+    const spans = [
+        ...(post.entities?.mentions ?? []).map((m) => ({ ...m, title: `@${m.username}` })),
+        ...(post.entities?.hashtags ?? []).map((h) => ({ ...h, title: `#${h.tag}` })),
+    ].sort((a, b) => a.start - b.start);
+
+    const out: React.ReactNode[] = [];
+    let last = 0;
+    for (const span of spans) {
+        out.push(post.content.slice(last, span.start));
+        out.push(
+            <span key={span.start} className="text-blue-600 font-medium" title={span.title}>
+                {post.content.slice(span.start, span.end)}
+            </span>
+        );
+        last = span.end;
+    }
+    out.push(post.content.slice(last));
+    return out;
+};
+
 const App: React.FC = () => {
     const [posts, setPosts] = useState<Post[]>([]);
     const [title, setTitle] = useState("");
     const [content, setContent] = useState("");
+    const [format, setFormat] = useState<PostFormat>("plain");
     const [file, setFile] = useState<File | null>(null);
     const [editId, setEditId] = useState<number | null>(null);
 
@@ -20,23 +43,35 @@ const App: React.FC = () => {
         }
     }
 
+    function applyEvent(event: PostEvent) {
+        setPosts((current) => {
+            const list = current || [];
+            if (event.type === "deleted") return list.filter((p) => p.id !== event.post_id);
+            if (list.some((p) => p.id === event.post_id)) {
+                return list.map((p) => (p.id === event.post_id ? event.post : p));
+            }
+            return [...list, event.post];
+        });
+    }
+
     useEffect(() => {
         loadPosts();
+        return subscribePosts(applyEvent, loadPosts);
     }, []);
 
     async function handleSubmit(e: React.FormEvent) {
         e.preventDefault();
         try {
             if (editId) {
-                await updatePost(editId, { content }, file || undefined);
+                await updatePost(editId, { content, format }, file || undefined);
                 setEditId(null);
             } else {
-                await createPost({ title, content });
+                await createPost({ title, content, format });
             }
             setTitle("");
             setContent("");
+            setFormat("plain");
             setFile(null);
-            loadPosts();
         } catch (err) {
             console.error(err);
         }
@@ -45,7 +80,6 @@ const App: React.FC = () => {
     async function handleDelete(id: number) {
         try {
             await deletePost(id);
-            loadPosts();
         } catch (err) {
             console.error(err);
         }
@@ -55,6 +89,7 @@ const App: React.FC = () => {
         setEditId(post.id);
         setTitle(post.title);
         setContent(post.content);
+        setFormat(post.format ?? "plain");
         setFile(null);
     }
 
@@ -85,6 +120,15 @@ const App: React.FC = () => {
                     className="w-full border rounded px-3 py-2 focus:outline-none focus:ring-2 focus:ring-blue-500"
                 />
 
+                <label className="flex items-center gap-2 text-sm">
+                    <input
+                        type="checkbox"
+                        checked={format === "markdown"}
+                        onChange={(e) => setFormat(e.target.checked ? "markdown" : "plain")}
+                    />
+                    Markdown
+                </label>
+
                 {/* File input only when editing */}
                 {editId && (
                     <input
@@ -132,7 +176,11 @@ const App: React.FC = () => {
                             >
                                 <div className="flex-1">
                                     <h3 className="text-xl font-semibold">{post.title}</h3>
-                                    <p className="mt-2">{post.content}</p>
+                                    {post.format === "markdown" && post.content_html ? (
+                                        <div className="mt-2 prose" dangerouslySetInnerHTML={{ __html: post.content_html }} />
+                                    ) : (
+                                        <p className="mt-2">{renderContent(post)}</p>
+                                    )}
                                 </div>
 
                                 {post.photo_url && (
@@ -1,6 +1,19 @@
-import type { Post, CreatePostPayload, EditPostPayload } from "./types";
+import type { Post, PostEvent, CreatePostPayload, EditPostPayload } from "./types";
 
 const API_URL = "http://localhost:3001/v1/post";
+const CSRF_URL = "http://localhost:3001/v1/csrf";
+
+let csrfToken: string | null = null;
+
+// Mutating requests must echo the CSRF cookie's token in the X-CSRF-Token header.
+async function getCSRFToken(): Promise<string> {
+    if (csrfToken) return csrfToken;
+    const res = await fetch(CSRF_URL, {credentials: "include", headers: {Accept: "application/json"}});
+    if (!res.ok) throw new Error("Failed to fetch CSRF token");
+    const data: { csrf_token: string } = await res.json();
+    csrfToken = data.csrf_token;
+    return csrfToken;
+}
 
 export async function getPosts(): Promise<Post[]> {
     const res = await fetch(API_URL, {headers: {Accept: "application/json"}});
@@ -8,14 +21,30 @@ export async function getPosts(): Promise<Post[]> {
     return res.json();
 }
 
+// subscribePosts follows the post stream. EventSource reconnects on its own and
+// resumes from the last event id; onReset means events were missed and the
+// list has to be reloaded.
+export function subscribePosts(onEvent: (event: PostEvent) => void, onReset: () => void): () => void {
+    const source = new EventSource(`${API_URL}/stream`);
+    const handle = (e: MessageEvent) => onEvent(JSON.parse(e.data));
+    source.addEventListener("post.created", handle);
+    source.addEventListener("post.edited", handle);
+    source.addEventListener("post.deleted", handle);
+    source.addEventListener("reset", onReset);
+    return () => source.close();
+}
+
 // Update post with optional photo
 export async function updatePost(id: number, payload: EditPostPayload, file?: File): Promise<Post> {
     const formData = new FormData();
     formData.append("content", payload.content);
+    if (payload.format) formData.append("format", payload.format);
     if (file) formData.append("photo", file);
 
     const res = await fetch(`${API_URL}/${id}`, {
         method: "PATCH",
+        credentials: "include",
+        headers: { "X-CSRF-Token": await getCSRFToken() },
         body: formData,
     });
 
@@ -26,7 +55,8 @@ export async function updatePost(id: number, payload: EditPostPayload, file?: Fi
 export async function deletePost(id: number): Promise<void> {
     const res = await fetch(`${API_URL}/${id}`, {
         method: "DELETE",
-        headers: { Accept: "application/json" },
+        credentials: "include",
+        headers: { Accept: "application/json", "X-CSRF-Token": await getCSRFToken() },
     });
     if (!res.ok) throw new Error("Failed to delete post");
 }
@@ -34,7 +64,12 @@ export async function deletePost(id: number): Promise<void> {
 export async function createPost(payload: CreatePostPayload): Promise<Post> {
     const res = await fetch(API_URL, {
         method: "POST",
-        headers: { "Content-Type": "application/json", Accept: "application/json" },
+        credentials: "include",
+        headers: {
+            "Content-Type": "application/json",
+            Accept: "application/json",
+            "X-CSRF-Token": await getCSRFToken(),
+        },
         body: JSON.stringify(payload),
     });
     if (!res.ok) throw new Error("Failed to create post");
@@ -4,13 +4,35 @@ export interface Post {
     content: string;
     author_email: string;
     photo_url: string,
+    entities?: Entities;
+    format?: PostFormat;
+    // Sanitised by the server, safe to insert as HTML.
+    content_html?: string;
+}
+
+export type PostFormat = "plain" | "markdown";
+
+// Offsets are UTF-16 indexes into content, so they work with String.slice.
+export interface Entities {
+    mentions: { user_id: number; username: string; start: number; end: number }[];
+    hashtags: { tag: string; start: number; end: number }[];
+}
+
+export interface PostEvent {
+    id: number;
+    type: "created" | "edited" | "deleted";
+    post_id: number;
+    post: Post;
+    created_at: string;
 }
 
 export interface CreatePostPayload {
     title: string;
     content: string;
+    format?: PostFormat;
 }
 
 export interface EditPostPayload {
     content: string;
+    format?: PostFormat;
 }